@@ -3,6 +3,7 @@
 #include <linux/bpf.h>
 #include <linux/if_ether.h>
 #include <linux/ip.h>
+#include <linux/ipv6.h>
 #include <linux/in.h>
 #include <linux/tcp.h>
 #include <linux/udp.h>
@@ -10,19 +11,108 @@
 #include <bpf/bpf_helpers.h>
 #include <bpf/bpf_endian.h>
 
+/* Maximum number of IPv6 extension headers walked before giving up. */
+#define IPV6_EXT_MAX 6
+
+/* Fragment offset bits of the IPv6 fragment header. */
+#define IPV6_FRAG_OFFSET 0xfff8
+
+/*
+ * Flags stored as the value of monitored_ports. PORT_MONITORED is always
+ * set; PORT_SRC_PORT keys flows to the port by source port as well.
+ */
+#define PORT_MONITORED 0x01
+#define PORT_SRC_PORT  0x02
+
+/*
+ * Flags stored in flow_key.flags. FLOW_SRC_PORT marks a key recorded with
+ * src_port, so user space never has to guess the key mode from src_port.
+ */
+#define FLOW_SRC_PORT 0x01
+
+/* Encapsulations peeled before the client packet is parsed, see decap. */
+#define DECAP_VLAN  0x01
+#define DECAP_VXLAN 0x02
+#define DECAP_GRE   0x04
+#define DECAP_IPIP  0x08
+
+/* Maximum number of VLAN tags peeled, two for QinQ. */
+#define VLAN_MAX 2
+
+/*
+ * Bytes the TC programs make linear before parsing when decap is enabled:
+ * enough for two VLAN tags, an outer IPv6 header, a GRE or VXLAN header,
+ * an inner Ethernet and IPv6 header and a TCP header. Drivers often leave
+ * the headers of tunnelled packets in paged data, out of reach of direct
+ * packet access.
+ */
+#define DECAP_PULL_LEN 256
+
+/* GRE flag bits (RFC 2890) that add a 4-byte field, and the version. */
+#define GRE_FLAG_CSUM    0x8000
+#define GRE_FLAG_KEY     0x2000
+#define GRE_FLAG_SEQ     0x1000
+#define GRE_VERSION_MASK 0x0007
+
+/*
+ * Set by user space before loading. decap holds DECAP_* flags, vxlan_port
+ * the UDP port VXLAN is recognised on.
+ */
+volatile const __u32 decap = 0;
+volatile const __u16 vxlan_port = 4789;
+
+/*
+ * src_ip holds a 128-bit address. IPv4 sources are stored as IPv4-mapped
+ * IPv6 addresses (::ffff:a.b.c.d) so both families share one key layout.
+ * src_port is zero unless the destination port has PORT_SRC_PORT set, in
+ * which case flags has FLOW_SRC_PORT set.
+ *
+ * Egress flows use the same key seen from the client: src_ip is the client
+ * the packet is sent to, dst_port the server port it is sent from and
+ * src_port the client port, so a reply matches the ingress flow it answers.
+ */
 struct flow_key {
-	__u32 src_ip;
+	__u8  src_ip[16];
 	__u16 dst_port;
+	__u16 src_port;
 	__u8  proto;
-	__u8  _pad;
+	__u8  flags;
 };
 
 struct flow_info {
 	__u64 packets;
 	__u64 bytes;
+	__u64 first_seen_ns;
 	__u64 last_seen_ns;
 };
 
+enum flow_counter {
+	FLOW_COUNTER_INSERTS,
+	FLOW_COUNTER_MAX,
+};
+
+struct vlan_hdr {
+	__be16 tci;
+	__be16 proto;
+};
+
+struct gre_hdr {
+	__be16 flags;
+	__be16 proto;
+};
+
+struct vxlan_hdr {
+	__be32 flags;
+	__be32 vni;
+};
+
+struct ipv6_frag_hdr {
+	__u8   nexthdr;
+	__u8   reserved;
+	__be16 frag_off;
+	__be32 identification;
+};
+
 struct {
 	__uint(type, BPF_MAP_TYPE_LRU_HASH);
 	__uint(max_entries, 100000);
@@ -30,6 +120,14 @@ struct {
 	__type(value, struct flow_info);
 } flow_stats SEC(".maps");
 
+/* Server-to-client traffic, sized like flow_stats by user space. */
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 100000);
+	__type(key, struct flow_key);
+	__type(value, struct flow_info);
+} egress_stats SEC(".maps");
+
 struct {
 	__uint(type, BPF_MAP_TYPE_HASH);
 	__uint(max_entries, 1000);
@@ -37,68 +135,355 @@ struct {
 	__type(value, __u8);
 } monitored_ports SEC(".maps");
 
-SEC("tc")
-int flow_monitor(struct __sk_buff *skb)
+/*
+ * Per-CPU event counters. User space compares FLOW_COUNTER_INSERTS against
+ * the live entry count of flow_stats to estimate LRU evictions.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, FLOW_COUNTER_MAX);
+	__type(key, __u32);
+	__type(value, __u64);
+} flow_counters SEC(".maps");
+
+static __always_inline void count_event(__u32 idx)
+{
+	__u64 *val = bpf_map_lookup_elem(&flow_counters, &idx);
+	if (val)
+		*val += 1;
+}
+
+static __always_inline int parse_ipv4(void *data, void *data_end, struct flow_key *key, void **l4, int egress)
+{
+	struct iphdr *ip = data;
+	if ((void *)(ip + 1) > data_end)
+		return -1;
+
+	__u8 ihl = ip->ihl;
+	if (ihl < 5)
+		return -1;
+
+	key->src_ip[10] = 0xff;
+	key->src_ip[11] = 0xff;
+	if (egress)
+		__builtin_memcpy(&key->src_ip[12], &ip->daddr, sizeof(ip->daddr));
+	else
+		__builtin_memcpy(&key->src_ip[12], &ip->saddr, sizeof(ip->saddr));
+	key->proto = ip->protocol;
+
+	*l4 = data + (ihl * 4);
+	return 0;
+}
+
+static __always_inline int parse_ipv6(void *data, void *data_end, struct flow_key *key, void **l4, int egress)
 {
-	void *data = (void *)(long)skb->data;
-	void *data_end = (void *)(long)skb->data_end;
+	struct ipv6hdr *ip6 = data;
+	if ((void *)(ip6 + 1) > data_end)
+		return -1;
+
+	if (egress)
+		__builtin_memcpy(key->src_ip, &ip6->daddr, sizeof(key->src_ip));
+	else
+		__builtin_memcpy(key->src_ip, &ip6->saddr, sizeof(key->src_ip));
+
+	__u8 nexthdr = ip6->nexthdr;
+	void *hdr = (void *)(ip6 + 1);
+
+#pragma unroll
+	for (int i = 0; i < IPV6_EXT_MAX; i++) {
+		if (nexthdr == IPPROTO_HOPOPTS || nexthdr == IPPROTO_ROUTING ||
+		    nexthdr == IPPROTO_DSTOPTS) {
+			struct ipv6_opt_hdr *opt = hdr;
+			if ((void *)(opt + 1) > data_end)
+				return -1;
+			nexthdr = opt->nexthdr;
+			hdr += (opt->hdrlen + 1) * 8;
+		} else if (nexthdr == IPPROTO_AH) {
+			struct ipv6_opt_hdr *opt = hdr;
+			if ((void *)(opt + 1) > data_end)
+				return -1;
+			nexthdr = opt->nexthdr;
+			hdr += (opt->hdrlen + 2) * 4;
+		} else if (nexthdr == IPPROTO_FRAGMENT) {
+			struct ipv6_frag_hdr *frag = hdr;
+			if ((void *)(frag + 1) > data_end)
+				return -1;
+			/* Only the first fragment carries the L4 header. */
+			if (frag->frag_off & bpf_htons(IPV6_FRAG_OFFSET))
+				return -1;
+			nexthdr = frag->nexthdr;
+			hdr = (void *)(frag + 1);
+		} else {
+			break;
+		}
+	}
+
+	key->proto = nexthdr;
+	*l4 = hdr;
+	return 0;
+}
+
+static __always_inline int parse_l3(void *data, void *data_end, __be16 proto, struct flow_key *key, void **l4, int egress)
+{
+	if (proto == bpf_htons(ETH_P_IP))
+		return parse_ipv4(data, data_end, key, l4, egress);
+	if (proto == bpf_htons(ETH_P_IPV6))
+		return parse_ipv6(data, data_end, key, l4, egress);
+	return -1;
+}
+
+/*
+ * skip_vlan returns the start of the network header after up to VLAN_MAX
+ * 802.1Q or 802.1ad tags when vlan is set, and sets *proto to its
+ * ethertype. A tag offloaded into the skb is not in data and needs no
+ * peeling.
+ */
+static __always_inline void *skip_vlan(void *data, void *data_end, __be16 *proto, int vlan)
+{
+	if (!vlan)
+		return data;
+
+#pragma unroll
+	for (int i = 0; i < VLAN_MAX; i++) {
+		if (*proto != bpf_htons(ETH_P_8021Q) && *proto != bpf_htons(ETH_P_8021AD))
+			break;
+		struct vlan_hdr *vlan = data;
+		if ((void *)(vlan + 1) > data_end)
+			return NULL;
+		*proto = vlan->proto;
+		data = (void *)(vlan + 1);
+	}
+	return data;
+}
 
+static __always_inline void *skip_eth(void *data, void *data_end, __be16 *proto, int vlan)
+{
 	struct ethhdr *eth = data;
 	if ((void *)(eth + 1) > data_end)
-		return TC_ACT_OK;
+		return NULL;
+	*proto = eth->h_proto;
+	return skip_vlan((void *)(eth + 1), data_end, proto, vlan);
+}
 
-	if (eth->h_proto != bpf_htons(ETH_P_IP))
-		return TC_ACT_OK;
+/*
+ * decap_tunnel returns the network header inside the tunnel whose outer IP
+ * header was parsed into key, and sets *proto to its ethertype. It returns
+ * NULL when the packet is not in an enabled tunnel.
+ */
+static __always_inline void *decap_tunnel(void *l4, void *data_end, struct flow_key *key, __be16 *proto, int vlan)
+{
+	if ((decap & DECAP_IPIP) && key->proto == IPPROTO_IPIP) {
+		*proto = bpf_htons(ETH_P_IP);
+		return l4;
+	}
+	if ((decap & DECAP_IPIP) && key->proto == IPPROTO_IPV6) {
+		*proto = bpf_htons(ETH_P_IPV6);
+		return l4;
+	}
 
-	struct iphdr *ip = (void *)(eth + 1);
-	if ((void *)(ip + 1) > data_end)
-		return TC_ACT_OK;
+	if ((decap & DECAP_GRE) && key->proto == IPPROTO_GRE) {
+		struct gre_hdr *gre = l4;
+		if ((void *)(gre + 1) > data_end)
+			return NULL;
 
-	__u8 ihl = ip->ihl;
-	if (ihl < 5)
-		return TC_ACT_OK;
+		__u16 flags = bpf_ntohs(gre->flags);
+		if (flags & GRE_VERSION_MASK)
+			return NULL;
 
-	struct flow_key key = {0};
-	key.src_ip = ip->saddr;
-	key.proto = ip->protocol;
+		void *inner = (void *)(gre + 1);
+		if (flags & GRE_FLAG_CSUM)
+			inner += 4;
+		if (flags & GRE_FLAG_KEY)
+			inner += 4;
+		if (flags & GRE_FLAG_SEQ)
+			inner += 4;
+
+		*proto = gre->proto;
+		if (*proto == bpf_htons(ETH_P_TEB))
+			return skip_eth(inner, data_end, proto, vlan);
+		return inner;
+	}
+
+	if ((decap & DECAP_VXLAN) && key->proto == IPPROTO_UDP) {
+		struct udphdr *udp = l4;
+		if ((void *)(udp + 1) > data_end)
+			return NULL;
+		if (udp->dest != bpf_htons(vxlan_port))
+			return NULL;
+		return skip_eth((void *)(udp + 1) + sizeof(struct vxlan_hdr), data_end, proto, vlan);
+	}
 
-	__u16 dst_port = 0;
-	void *l4 = (void *)ip + (ihl * 4);
+	return NULL;
+}
+
+static __always_inline int parse_l4(void *l4, void *data_end, struct flow_key *key, __u16 *src_port, int egress)
+{
+	__be16 source, dest;
 
-	if (ip->protocol == IPPROTO_TCP) {
-		if (l4 + sizeof(struct tcphdr) > data_end)
-			return TC_ACT_OK;
+	if (key->proto == IPPROTO_TCP) {
 		struct tcphdr *tcp = l4;
-		dst_port = bpf_ntohs(tcp->dest);
-	} else if (ip->protocol == IPPROTO_UDP) {
-		if (l4 + sizeof(struct udphdr) > data_end)
-			return TC_ACT_OK;
+		if ((void *)(tcp + 1) > data_end)
+			return -1;
+		source = tcp->source;
+		dest = tcp->dest;
+	} else if (key->proto == IPPROTO_UDP) {
 		struct udphdr *udp = l4;
-		dst_port = bpf_ntohs(udp->dest);
+		if ((void *)(udp + 1) > data_end)
+			return -1;
+		source = udp->source;
+		dest = udp->dest;
 	} else {
-		return TC_ACT_OK;
+		return -1;
 	}
 
-	key.dst_port = dst_port;
+	if (egress) {
+		key->dst_port = bpf_ntohs(source);
+		*src_port = bpf_ntohs(dest);
+	} else {
+		key->dst_port = bpf_ntohs(dest);
+		*src_port = bpf_ntohs(source);
+	}
+	return 0;
+}
 
-	if (!bpf_map_lookup_elem(&monitored_ports, &dst_port))
-		return TC_ACT_OK;
+static __always_inline void record_flow(void *flows, struct flow_key *key, __u32 len)
+{
+	__u64 now = bpf_ktime_get_ns();
 
-	struct flow_info *info = bpf_map_lookup_elem(&flow_stats, &key);
-	if (info) {
-		__sync_fetch_and_add(&info->packets, 1);
-		__sync_fetch_and_add(&info->bytes, skb->len);
-		info->last_seen_ns = bpf_ktime_get_ns();
-	} else {
+	struct flow_info *info = bpf_map_lookup_elem(flows, key);
+	if (!info) {
 		struct flow_info new_info = {
 			.packets = 1,
-			.bytes = skb->len,
-			.last_seen_ns = bpf_ktime_get_ns(),
+			.bytes = len,
+			.first_seen_ns = now,
+			.last_seen_ns = now,
 		};
-		bpf_map_update_elem(&flow_stats, &key, &new_info, BPF_ANY);
+		if (bpf_map_update_elem(flows, key, &new_info, BPF_NOEXIST) == 0) {
+			if (flows == &flow_stats)
+				count_event(FLOW_COUNTER_INSERTS);
+			return;
+		}
+
+		/* Another CPU inserted the flow first. */
+		info = bpf_map_lookup_elem(flows, key);
+		if (!info)
+			return;
 	}
 
-	return TC_ACT_OK;
+	__sync_fetch_and_add(&info->packets, 1);
+	__sync_fetch_and_add(&info->bytes, len);
+	info->last_seen_ns = now;
+}
+
+/*
+ * handle_packet records one Ethernet frame. It is shared by the TC and XDP
+ * programs; len is the frame length as the hook sees it. vlan peels VLAN
+ * tags, whose bytes are not counted: with hardware VLAN offload TC programs
+ * get the frame with the tag stripped into the skb, while XDP programs may
+ * see it in the frame, so counting without tags keeps byte counts the same
+ * whatever the attach mode.
+ *
+ * A tunnelled packet is recorded under the inner client address and port,
+ * and its length without the tunnel headers so thresholds do not depend on
+ * the encapsulation. One level of tunnel is peeled.
+ */
+static __always_inline void handle_packet(void *data, void *data_end, __u32 len, void *flows, int egress, int vlan)
+{
+	__be16 proto;
+	void *l3 = skip_eth(data, data_end, &proto, vlan);
+	if (!l3)
+		return;
+
+	__u32 tags = l3 - data - sizeof(struct ethhdr);
+	if (tags >= len)
+		return;
+	len -= tags;
+
+	struct flow_key key = {0};
+	void *l4 = NULL;
+
+	if (parse_l3(l3, data_end, proto, &key, &l4, egress) < 0)
+		return;
+
+	if (decap & (DECAP_VXLAN | DECAP_GRE | DECAP_IPIP)) {
+		void *inner = decap_tunnel(l4, data_end, &key, &proto, vlan);
+		if (inner) {
+			__u32 overhead = inner - l3;
+			if (overhead >= len)
+				return;
+			len -= overhead;
+
+			__builtin_memset(&key, 0, sizeof(key));
+			if (parse_l3(inner, data_end, proto, &key, &l4, egress) < 0)
+				return;
+		}
+	}
+
+	__u16 src_port = 0;
+	if (parse_l4(l4, data_end, &key, &src_port, egress) < 0)
+		return;
+
+	__u8 *flags = bpf_map_lookup_elem(&monitored_ports, &key.dst_port);
+	if (!flags)
+		return;
+
+	if (*flags & PORT_SRC_PORT) {
+		key.src_port = src_port;
+		key.flags |= FLOW_SRC_PORT;
+	}
+
+	record_flow(flows, &key, len);
+}
+
+/*
+ * pull_headers makes the first DECAP_PULL_LEN bytes of the packet linear
+ * when decap is enabled and they are not already. It invalidates earlier
+ * data and data_end pointers, which callers must read again afterwards.
+ */
+static __always_inline void pull_headers(struct __sk_buff *skb)
+{
+	if (!decap)
+		return;
+
+	__u32 len = skb->len < DECAP_PULL_LEN ? skb->len : DECAP_PULL_LEN;
+	if ((void *)(long)skb->data + len > (void *)(long)skb->data_end)
+		bpf_skb_pull_data(skb, len);
+}
+
+/*
+ * Used by both the clsact and the tcx attach modes. TC_ACT_UNSPEC (TCX_NEXT
+ * for tcx) passes the packet on to the filters and programs after ours, so
+ * FlowLens only observes and never decides the verdict.
+ */
+SEC("tc")
+int flow_monitor(struct __sk_buff *skb)
+{
+	pull_headers(skb);
+	handle_packet((void *)(long)skb->data, (void *)(long)skb->data_end, skb->len, &flow_stats, 0, decap & DECAP_VLAN);
+	return TC_ACT_UNSPEC;
+}
+
+SEC("tc")
+int flow_monitor_egress(struct __sk_buff *skb)
+{
+	pull_headers(skb);
+	handle_packet((void *)(long)skb->data, (void *)(long)skb->data_end, skb->len, &egress_stats, 1, decap & DECAP_VLAN);
+	return TC_ACT_UNSPEC;
+}
+
+/*
+ * VLAN tags are always peeled here. A NIC with VLAN offload strips the tag
+ * into the skb before the TC programs run, but XDP may see it in the frame,
+ * and tagged frames would otherwise be missed without decap: [vlan].
+ */
+SEC("xdp")
+int flow_monitor_xdp(struct xdp_md *ctx)
+{
+	void *data = (void *)(long)ctx->data;
+	void *data_end = (void *)(long)ctx->data_end;
+
+	handle_packet(data, data_end, data_end - data, &flow_stats, 0, 1);
+	return XDP_PASS;
 }
 
 char __license[] SEC("license") = "GPL";
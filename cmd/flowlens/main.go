@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
@@ -12,23 +14,42 @@ import (
 	"time"
 
 	"github.com/rxtx-hosting/flowlens/internal/config"
+	"github.com/rxtx-hosting/flowlens/pkg/agones"
+	"github.com/rxtx-hosting/flowlens/pkg/calibration"
+	"github.com/rxtx-hosting/flowlens/pkg/clock"
+	"github.com/rxtx-hosting/flowlens/pkg/containerd"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
 	"github.com/rxtx-hosting/flowlens/pkg/docker"
 	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
 	"github.com/rxtx-hosting/flowlens/pkg/estimator"
 	"github.com/rxtx-hosting/flowlens/pkg/exporter"
+	"github.com/rxtx-hosting/flowlens/pkg/k8s"
+	"github.com/rxtx-hosting/flowlens/pkg/pterodactyl"
+	"github.com/rxtx-hosting/flowlens/pkg/static"
+	"k8s.io/client-go/dynamic"
 )
 
 var (
-	configPath = flag.String("config", "/etc/flowlens/config.yaml", "Path to configuration file")
-	ifaceName  = flag.String("interface", "", "Network interface to monitor (overrides config)")
+	configPath  = flag.String("config", "/etc/flowlens/config.yaml", "Path to configuration file")
+	ifaceName   = flag.String("interface", "", "Comma-separated network interfaces, glob patterns or \"auto\" to monitor (overrides config)")
+	cleanupPins = flag.Bool("cleanup-pins", false, "Remove the pinned eBPF maps and exit")
 )
 
 func main() {
 	flag.Parse()
 
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts FlowLens and blocks until it is told to stop. Failures are
+// returned rather than fatal so the deferred cleanup runs, above all the
+// detaching of the eBPF programs.
+func run() error {
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
 	var level slog.Level
@@ -50,55 +71,272 @@ func main() {
 	})
 	slog.SetDefault(slog.New(handler))
 
+	if *cleanupPins {
+		if err := ebpf.RemovePins(cfg.PinPath); err != nil {
+			return fmt.Errorf("failed to remove pinned maps: %w", err)
+		}
+		slog.Info("Removed pinned maps", "path", cfg.PinPath)
+		return nil
+	}
+
 	if *ifaceName != "" {
-		cfg.Interface = *ifaceName
+		cfg.Interfaces = splitList(*ifaceName)
 	}
 
-	slog.Info("Starting FlowLens", "interface", cfg.Interface)
+	slog.Info("Starting FlowLens", "interfaces", cfg.InterfaceList())
+
+	defaultParams := estimator.Params{
+		Strategy:      cfg.Strategy,
+		Activity:      cfg.PlayerActivityThreshold,
+		MinPackets:    cfg.MinPacketsThreshold,
+		MinBytes:      cfg.MinBytesThreshold,
+		MinPacketRate: cfg.MinPacketRate,
+		RateIntervals: cfg.RateIntervals,
+		MinPacketSize: cfg.MinPacketSize,
+		MaxPacketSize: cfg.MaxPacketSize,
+		Bidirectional: cfg.Bidirectional,
+	}
+	if cfg.Bidirectional && !cfg.Egress {
+		return errors.New("option bidirectional needs egress: true")
+	}
+	if _, err := estimator.Lookup(defaultParams.Strategy); err != nil {
+		return fmt.Errorf("invalid strategy: %w", err)
+	}
+	profiles, err := estimatorProfiles(cfg, defaultParams)
+	if err != nil {
+		return err
+	}
+	if err := checkKeyMode("key_mode", cfg.KeyMode); err != nil {
+		return err
+	}
+	for name, pc := range cfg.Profiles {
+		if pc.KeyMode != "" {
+			if err := checkKeyMode(fmt.Sprintf("key_mode of profile %q", name), pc.KeyMode); err != nil {
+				return err
+			}
+		}
+	}
+	playerEstimator := estimator.NewEngine(defaultParams, clock.New())
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	dockerClient, err := docker.NewClient(cfg.DockerLabels, cfg.ServerIDSource, cfg.PortEnvVar)
+	attachMode, err := ebpf.ParseAttachMode(cfg.AttachMode)
+	if err != nil {
+		return fmt.Errorf("invalid attach_mode: %w", err)
+	}
+	tcConflict, err := ebpf.ParseTCConflict(cfg.TCConflict)
 	if err != nil {
-		log.Fatalf("Failed to initialize Docker client: %v", err)
+		return fmt.Errorf("invalid tc_conflict: %w", err)
+	}
+	decap, err := ebpf.ParseDecap(cfg.Decap, cfg.VXLANPort)
+	if err != nil {
+		return fmt.Errorf("invalid decap: %w", err)
 	}
-	defer dockerClient.Close()
 
-	ebpfMonitor, err := ebpf.NewMonitor(cfg.Interface)
+	pins, err := pinOptions(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize eBPF monitor: %v", err)
+		return err
+	}
+
+	ebpfMonitor, err := ebpf.NewMonitor(cfg.InterfaceList(), ebpf.MapSizes{
+		FlowStats:      uint32(cfg.EBPFMapSize),
+		MonitoredPorts: uint32(cfg.EBPFPortsMapSize),
+	}, pins, ebpf.AttachOptions{
+		Mode:       attachMode,
+		Egress:     cfg.Egress,
+		TCPriority: cfg.TCPriority,
+		TCHandle:   cfg.TCHandle,
+		TCConflict: tcConflict,
+	}, decap)
+	if err != nil {
+		return fmt.Errorf("failed to initialize eBPF monitor: %w", err)
 	}
 	defer ebpfMonitor.Close()
+	slog.Info("Attached to interfaces", "interfaces", ebpfMonitor.Interfaces())
+
+	go func() {
+		if err := ebpfMonitor.WatchLinks(ctx); err != nil {
+			slog.Error("Stopped watching for new interfaces", "error", err)
+		}
+	}()
+
+	var calibrator *calibration.Calibrator
+	if len(cfg.Calibration.Games) > 0 {
+		targets := make(map[string]calibration.Target, len(cfg.Calibration.Games))
+		for game, t := range cfg.Calibration.Games {
+			if _, ok := cfg.Profiles[game]; !ok {
+				return fmt.Errorf("calibration game %q is not a profile", game)
+			}
+			targets[game] = calibration.Target{Protocol: t.Protocol, Password: t.Password, Command: t.Command}
+		}
+		calibrator, err = calibration.NewCalibrator(cfg.Calibration.Host, cfg.Calibration.Interval, cfg.Calibration.Timeout, targets)
+		if err != nil {
+			return fmt.Errorf("failed to set up calibration: %w", err)
+		}
+		slog.Info("Calibrating against server player counts", "interval", cfg.Calibration.Interval)
+		go calibrator.Run(ctx)
+	}
 
-	playerEstimator := estimator.NewEstimator(cfg.PlayerActivityThreshold, cfg.MinPacketsThreshold, cfg.MinBytesThreshold)
+	// The HTTP servers report failures here so they stop FlowLens through
+	// the same cleanup as everything else.
+	serveErrs := make(chan error, 2)
 
 	apiServer := exporter.NewAPIServer(cfg.APIKey)
 
 	go func() {
 		slog.Info("Starting API server", "address", cfg.ServerAddr)
 		if err := apiServer.StartServer(cfg.ServerAddr); err != nil {
-			log.Fatalf("Failed to start API server: %v", err)
+			serveErrs <- fmt.Errorf("failed to start API server: %w", err)
 		}
 	}()
 
 	var promExporter *exporter.PrometheusExporter
 	if cfg.PrometheusAddr != "" {
-		promExporter = exporter.NewPrometheusExporter()
+		labelKeys := make([]string, 0, len(cfg.MetricLabels))
+		for key := range cfg.MetricLabels {
+			labelKeys = append(labelKeys, key)
+		}
+		promExporter = exporter.NewPrometheusExporter(labelKeys, cfg.MetricLabelMaxValues)
 		go func() {
 			slog.Info("Starting Prometheus server", "address", cfg.PrometheusAddr)
 			if err := promExporter.StartServer(cfg.PrometheusAddr); err != nil {
-				log.Fatalf("Failed to start Prometheus server: %v", err)
+				serveErrs <- fmt.Errorf("failed to start Prometheus server: %w", err)
 			}
 		}()
 	}
 
-	discoveryTicker := time.NewTicker(cfg.DiscoveryInterval)
-	defer discoveryTicker.Stop()
+	var agonesWatcher *agones.Watcher
+
+	var discoverers []discovery.Discoverer
+	for _, source := range cfg.Discovery {
+		switch source {
+		case "docker":
+			dockerClient, err := docker.NewClient(cfg.DockerLabels, cfg.ServerIDSource, cfg.PortEnvVar, cfg.PortSpecSource)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker client: %w", err)
+			}
+			defer dockerClient.Close()
+			dockerClient.SetLabelSources(cfg.MetricLabels)
+			dockerClient.SetProfileSource(cfg.ProfileSource)
+			discoverers = append(discoverers, docker.NewWatcher(dockerClient, cfg.DiscoveryInterval))
+
+		case "podman":
+			podmanClient, err := docker.NewPodmanClient(cfg.Podman.Socket, cfg.DockerLabels, cfg.ServerIDSource, cfg.PortEnvVar, cfg.PortSpecSource)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Podman client: %w", err)
+			}
+			defer podmanClient.Close()
+			podmanClient.SetLabelSources(cfg.MetricLabels)
+			podmanClient.SetProfileSource(cfg.ProfileSource)
+			discoverers = append(discoverers, docker.NewWatcher(podmanClient, cfg.DiscoveryInterval))
+
+		case "containerd":
+			containerdClient, err := containerd.NewClient(cfg.Containerd.Address, cfg.Containerd.Namespace, cfg.Containerd.PortsLabel, cfg.DockerLabels, cfg.ServerIDSource, cfg.PortEnvVar, cfg.PortSpecSource)
+			if err != nil {
+				return fmt.Errorf("failed to initialize containerd client: %w", err)
+			}
+			defer containerdClient.Close()
+			containerdClient.SetLabelSources(cfg.MetricLabels)
+			containerdClient.SetProfileSource(cfg.ProfileSource)
+			discoverers = append(discoverers, containerd.NewWatcher(containerdClient, cfg.DiscoveryInterval))
+
+		case "kubernetes":
+			k8sClient, err := k8s.NewClientset(cfg.Kubernetes.Kubeconfig)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Kubernetes client: %w", err)
+			}
+			if cfg.Kubernetes.NodeName == "" {
+				return errors.New("kubernetes.node_name is required, set it or the NODE_NAME environment variable")
+			}
+			slog.Info("Discovering pods", "node", cfg.Kubernetes.NodeName, "selector", cfg.Kubernetes.LabelSelector)
+			discoverers = append(discoverers, k8s.NewWatcher(k8sClient, k8s.Options{
+				NodeName:         cfg.Kubernetes.NodeName,
+				LabelSelector:    cfg.Kubernetes.LabelSelector,
+				ServerIDSource:   cfg.Kubernetes.ServerIDSource,
+				PortsAnnotation:  cfg.Kubernetes.PortsAnnotation,
+				NodePortServices: cfg.Kubernetes.NodePortServices,
+				LabelSources:     cfg.MetricLabels,
+				ProfileSource:    cfg.ProfileSource,
+				ResyncInterval:   cfg.DiscoveryInterval,
+			}))
+
+		case "agones":
+			restConfig, err := k8s.RESTConfig(cfg.Kubernetes.Kubeconfig)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Kubernetes client: %w", err)
+			}
+			dynClient, err := dynamic.NewForConfig(restConfig)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Kubernetes client: %w", err)
+			}
+			if cfg.Kubernetes.NodeName == "" {
+				return errors.New("kubernetes.node_name is required, set it or the NODE_NAME environment variable")
+			}
+			switch agones.WriteBack(cfg.Agones.WriteBack) {
+			case agones.WriteBackNone, agones.WriteBackAnnotation, agones.WriteBackStatus:
+			default:
+				return fmt.Errorf("invalid agones.write_back %q, expected annotation or status", cfg.Agones.WriteBack)
+			}
+			slog.Info("Discovering GameServers", "node", cfg.Kubernetes.NodeName, "namespace", cfg.Agones.Namespace, "write_back", cfg.Agones.WriteBack)
+			agonesWatcher = agones.NewWatcher(dynClient, agones.Options{
+				NodeName:       cfg.Kubernetes.NodeName,
+				Namespace:      cfg.Agones.Namespace,
+				LabelSelector:  cfg.Kubernetes.LabelSelector,
+				WriteBack:      agones.WriteBack(cfg.Agones.WriteBack),
+				Annotation:     cfg.Agones.Annotation,
+				Counter:        cfg.Agones.Counter,
+				LabelSources:   cfg.MetricLabels,
+				ProfileSource:  cfg.ProfileSource,
+				ResyncInterval: cfg.DiscoveryInterval,
+			})
+			discoverers = append(discoverers, agonesWatcher)
+
+		case "static":
+			if cfg.Static.File == "" {
+				return errors.New("static.file is required for static discovery")
+			}
+			discoverers = append(discoverers, static.NewWatcher(cfg.Static.File))
+
+		default:
+			return fmt.Errorf("invalid discovery %q, expected docker, podman, containerd, kubernetes, agones or static", source)
+		}
+	}
+	if len(discoverers) == 0 {
+		return errors.New("no discovery source configured")
+	}
+	slog.Info("Starting discovery", "sources", cfg.Discovery)
+
+	manager := discovery.NewManager(discoverers...)
+	if cfg.Panel.URL != "" {
+		if cfg.Panel.CacheTTL < pterodactyl.MinCacheTTL {
+			return fmt.Errorf("invalid panel.cache_ttl %s, must be at least %s", cfg.Panel.CacheTTL, pterodactyl.MinCacheTTL)
+		}
+		slog.Info("Enriching servers from panel", "url", cfg.Panel.URL, "cache_ttl", cfg.Panel.CacheTTL)
+		manager.AddEnricher(pterodactyl.NewEnricher(pterodactyl.NewClient(cfg.Panel.URL, cfg.Panel.APIKey, nil), cfg.Panel.CacheTTL))
+	}
+
+	serverUpdates := make(chan []discovery.ServerMetadata, 1)
+	go manager.Run(ctx, serverUpdates)
 
 	metricsTicker := time.NewTicker(cfg.MetricsInterval)
 	defer metricsTicker.Stop()
 
+	maxActivity := max(cfg.PlayerActivityThreshold, profiles.MaxActivity())
+	if cfg.FlowRetention < maxActivity {
+		slog.Warn("flow_retention is shorter than player_activity_threshold, raising it", "flow_retention", cfg.FlowRetention, "player_activity_threshold", maxActivity)
+		cfg.FlowRetention = maxActivity
+	}
+
+	gcTicker := time.NewTicker(cfg.GCInterval)
+	defer gcTicker.Stop()
+
+	var lastEvictions uint64
+	serverLabels := make(map[string]map[string]string)
+	unknownProfiles := make(map[string]string)
+	flows := make(map[ebpf.FlowKey]ebpf.FlowInfo)
+	egressFlows := make(map[ebpf.FlowKey]ebpf.FlowInfo)
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -108,34 +346,249 @@ func main() {
 		select {
 		case <-sigCh:
 			slog.Info("Received shutdown signal, cleaning up...")
-			return
+			return nil
 
-		case <-discoveryTicker.C:
-			servers, err := dockerClient.DiscoverGameServers(ctx)
-			if err != nil {
-				slog.Error("Error discovering game servers", "error", err)
-				continue
-			}
+		case err := <-serveErrs:
+			return err
+
+		case servers := <-serverUpdates:
 			slog.Info("Discovered game servers", "count", len(servers))
-			if err := ebpfMonitor.UpdateServers(servers); err != nil {
+			if err := ebpfMonitor.UpdateServers(servers, sourcePortServers(cfg, profiles, servers), manager.Complete()); err != nil {
 				slog.Error("Error updating monitored servers", "error", err)
 				continue
 			}
 
+			playerEstimator.SetServerParams(serverParams(profiles, servers, unknownProfiles))
+			apiServer.UpdateServers(servers)
+			if promExporter != nil {
+				promExporter.UpdateServers(servers)
+			}
+			if calibrator != nil {
+				calibrator.SetServers(servers, serverGames(profiles, servers))
+			}
+
+			serverLabels = make(map[string]map[string]string, len(servers))
+			for _, srv := range servers {
+				if len(srv.Labels) > 0 {
+					serverLabels[srv.ServerID] = srv.Labels
+				}
+			}
+
+		case <-gcTicker.C:
+			gcStats, err := ebpfMonitor.GC(cfg.FlowRetention)
+			if err != nil {
+				slog.Error("Error collecting stale flows", "error", err)
+				continue
+			}
+			slog.Debug("Collected stale flows", "scanned", gcStats.Scanned, "stale", gcStats.Stale, "unmonitored", gcStats.Unmonitored)
+
+			if promExporter != nil {
+				promExporter.UpdateGC(gcStats)
+			}
+
 		case <-metricsTicker.C:
-			flows, err := ebpfMonitor.ReadFlows()
+			err := ebpfMonitor.ReadFlowsInto(flows, &ebpf.FlowFilter{Ports: ebpfMonitor.MonitoredPorts()})
 			if err != nil {
 				slog.Error("Error reading flows", "error", err)
 				continue
 			}
 
-			stats := playerEstimator.EstimatePlayers(flows, ebpfMonitor.GetServerMap())
+			err = ebpfMonitor.ReadEgressInto(egressFlows, &ebpf.FlowFilter{Ports: ebpfMonitor.MonitoredPorts()})
+			if err != nil {
+				slog.Error("Error reading egress flows", "error", err)
+				continue
+			}
+
+			if pressure, err := ebpfMonitor.MapPressure(); err != nil {
+				slog.Error("Error reading flow map pressure", "error", err)
+			} else {
+				if pressure.EstimatedEvictions > lastEvictions {
+					slog.Warn("Flow map is evicting entries", "evicted", pressure.EstimatedEvictions-lastEvictions, "entries", pressure.Entries, "capacity", pressure.Capacity)
+				} else if pressure.Utilization >= 0.9 {
+					slog.Warn("Flow map is nearly full", "entries", pressure.Entries, "capacity", pressure.Capacity)
+				}
+				lastEvictions = pressure.EstimatedEvictions
+
+				apiServer.UpdateMapPressure(pressure)
+				if promExporter != nil {
+					promExporter.UpdateMapPressure(pressure)
+				}
+			}
+
+			stats := playerEstimator.EstimatePlayers(flows, egressFlows, ebpfMonitor.GetServerMap())
+			if playerEstimator.Baseline() {
+				// Nothing is measured yet, publishing would report every
+				// server empty and write zeros back to Agones.
+				slog.Info("Recorded baseline flow counters, estimating players from the next interval")
+				continue
+			}
 			slog.Info("Estimated players", "servers", len(stats))
 
+			for i := range stats {
+				stats[i].Labels = serverLabels[stats[i].ServerID]
+			}
+
+			if calibrator != nil {
+				actual := calibrator.Record(stats, playerEstimator)
+				for i := range stats {
+					stats[i].ActualPlayers, stats[i].Calibrated = actual[stats[i].ServerID]
+				}
+				apiServer.UpdateCalibration(calibrator.Report())
+			}
+
+			sessions := playerEstimator.SessionUpdate()
+
+			apiServer.UpdateSessions(sessions)
 			apiServer.UpdateStats(stats)
+			if agonesWatcher != nil {
+				agonesWatcher.ReportPlayers(stats)
+			}
 			if promExporter != nil {
+				promExporter.UpdateSessions(sessions)
 				promExporter.UpdateStats(stats)
 			}
 		}
 	}
 }
+
+// estimatorProfiles builds the configured profiles. Settings a profile leaves
+// unset inherit defaults.
+func estimatorProfiles(cfg *config.Config, defaults estimator.Params) (*estimator.Profiles, error) {
+	profiles := make([]estimator.Profile, 0, len(cfg.Profiles))
+	for name, pc := range cfg.Profiles {
+		p := defaults
+		if pc.Strategy != "" {
+			p.Strategy = pc.Strategy
+		}
+		if pc.PlayerActivityThreshold != nil {
+			p.Activity = *pc.PlayerActivityThreshold
+		}
+		if pc.MinPacketsThreshold != nil {
+			p.MinPackets = *pc.MinPacketsThreshold
+		}
+		if pc.MinBytesThreshold != nil {
+			p.MinBytes = *pc.MinBytesThreshold
+		}
+		if pc.MinPacketRate != nil {
+			p.MinPacketRate = *pc.MinPacketRate
+		}
+		if pc.RateIntervals != nil {
+			p.RateIntervals = *pc.RateIntervals
+		}
+		if pc.MinPacketSize != nil {
+			p.MinPacketSize = *pc.MinPacketSize
+		}
+		if pc.MaxPacketSize != nil {
+			p.MaxPacketSize = *pc.MaxPacketSize
+		}
+		if pc.Bidirectional != nil {
+			p.Bidirectional = *pc.Bidirectional
+		}
+		if p.Bidirectional && !cfg.Egress {
+			return nil, fmt.Errorf("profile %q sets bidirectional, which needs egress: true", name)
+		}
+		if p.Activity <= 0 {
+			return nil, fmt.Errorf("invalid player_activity_threshold in profile %q", name)
+		}
+		if _, err := estimator.Lookup(p.Strategy); err != nil {
+			return nil, fmt.Errorf("invalid strategy in profile %q: %w", name, err)
+		}
+		profiles = append(profiles, estimator.Profile{Name: name, Images: pc.Images, Params: p})
+	}
+	return estimator.NewProfiles(profiles), nil
+}
+
+// serverParams picks the estimator parameters of every server that has a
+// profile. A server naming an unknown profile is warned about once; warned
+// holds the unknown profile name per server ID across calls.
+func serverParams(profiles *estimator.Profiles, servers []discovery.ServerMetadata, warned map[string]string) map[string]estimator.Params {
+	params := make(map[string]estimator.Params)
+	current := make(map[string]bool, len(servers))
+	for _, srv := range servers {
+		current[srv.ServerID] = true
+		if srv.Profile == "" || profiles.Has(srv.Profile) {
+			delete(warned, srv.ServerID)
+		} else if warned[srv.ServerID] != srv.Profile {
+			slog.Warn("Unknown profile, matching by image instead", "server_id", srv.ServerID, "profile", srv.Profile, "image", srv.Image)
+			warned[srv.ServerID] = srv.Profile
+		}
+
+		profile, ok := profiles.Select(srv.Profile, srv.Image)
+		if !ok {
+			continue
+		}
+		params[srv.ServerID] = profile.Params
+	}
+
+	for serverID := range warned {
+		if !current[serverID] {
+			delete(warned, serverID)
+		}
+	}
+	return params
+}
+
+// serverGames maps every server that has a profile to the profile's name.
+func serverGames(profiles *estimator.Profiles, servers []discovery.ServerMetadata) map[string]string {
+	games := make(map[string]string)
+	for _, srv := range servers {
+		if profile, ok := profiles.Select(srv.Profile, srv.Image); ok {
+			games[srv.ServerID] = profile.Name
+		}
+	}
+	return games
+}
+
+// sourcePortServers returns the servers whose flows are keyed by source port,
+// those with key_mode ip_port globally or in their profile.
+func sourcePortServers(cfg *config.Config, profiles *estimator.Profiles, servers []discovery.ServerMetadata) map[string]bool {
+	selected := make(map[string]bool)
+	for _, srv := range servers {
+		mode := cfg.KeyMode
+		if profile, ok := profiles.Select(srv.Profile, srv.Image); ok && cfg.Profiles[profile.Name].KeyMode != "" {
+			mode = cfg.Profiles[profile.Name].KeyMode
+		}
+		if mode == "ip_port" {
+			selected[srv.ServerID] = true
+		}
+	}
+	return selected
+}
+
+func checkKeyMode(option, mode string) error {
+	switch mode {
+	case "ip", "ip_port":
+		return nil
+	default:
+		return fmt.Errorf("invalid %s %q, expected ip or ip_port", option, mode)
+	}
+}
+
+// splitList splits a comma-separated flag value, trimming whitespace and
+// dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func pinOptions(cfg *config.Config) (ebpf.PinOptions, error) {
+	if !cfg.PinMaps {
+		return ebpf.PinOptions{}, nil
+	}
+
+	switch cfg.PinIncompatible {
+	case "replace", "fail":
+	default:
+		return ebpf.PinOptions{}, fmt.Errorf("invalid pin_incompatible %q, expected replace or fail", cfg.PinIncompatible)
+	}
+
+	return ebpf.PinOptions{
+		Path:                cfg.PinPath,
+		ReplaceIncompatible: cfg.PinIncompatible == "replace",
+	}, nil
+}
@@ -4,40 +4,207 @@ import (
 	"os"
 	"time"
 
+	"github.com/rxtx-hosting/flowlens/pkg/containerd"
+	"github.com/rxtx-hosting/flowlens/pkg/docker"
+	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Interface               string            `yaml:"interface"`
-	EBPFMapSize             int               `yaml:"ebpf_map_size"`
-	DiscoveryInterval       time.Duration     `yaml:"discovery_interval"`
-	MetricsInterval         time.Duration     `yaml:"metrics_interval"`
-	PlayerActivityThreshold time.Duration     `yaml:"player_activity_threshold"`
-	MinPacketsThreshold     uint64            `yaml:"min_packets_threshold"`
-	MinBytesThreshold       uint64            `yaml:"min_bytes_threshold"`
-	ServerAddr              string            `yaml:"server_addr"`
-	APIKey                  string            `yaml:"api_key"`
-	PrometheusAddr          string            `yaml:"prometheus_addr"`
-	DockerLabels            map[string]string `yaml:"docker_labels"`
-	ServerIDSource          string            `yaml:"server_id_source"`
-	PortEnvVar              string            `yaml:"port_env_var"`
-	LogLevel                string            `yaml:"log_level"`
+	Interface               string                   `yaml:"interface"`
+	Interfaces              []string                 `yaml:"interfaces"`
+	EBPFMapSize             int                      `yaml:"ebpf_map_size"`
+	EBPFPortsMapSize        int                      `yaml:"ebpf_ports_map_size"`
+	PinMaps                 bool                     `yaml:"pin_maps"`
+	Egress                  bool                     `yaml:"egress"`
+	AttachMode              string                   `yaml:"attach_mode"`
+	TCPriority              uint16                   `yaml:"tc_priority"`
+	TCHandle                uint32                   `yaml:"tc_handle"`
+	TCConflict              string                   `yaml:"tc_conflict"`
+	Decap                   StringList               `yaml:"decap"`
+	VXLANPort               uint16                   `yaml:"vxlan_port"`
+	PinPath                 string                   `yaml:"pin_path"`
+	PinIncompatible         string                   `yaml:"pin_incompatible"`
+	Discovery               StringList               `yaml:"discovery"`
+	DiscoveryInterval       time.Duration            `yaml:"discovery_interval"`
+	MetricsInterval         time.Duration            `yaml:"metrics_interval"`
+	PlayerActivityThreshold time.Duration            `yaml:"player_activity_threshold"`
+	FlowRetention           time.Duration            `yaml:"flow_retention"`
+	GCInterval              time.Duration            `yaml:"gc_interval"`
+	MinPacketsThreshold     uint64                   `yaml:"min_packets_threshold"`
+	MinBytesThreshold       uint64                   `yaml:"min_bytes_threshold"`
+	Strategy                string                   `yaml:"strategy"`
+	MinPacketRate           float64                  `yaml:"min_packet_rate"`
+	RateIntervals           int                      `yaml:"rate_intervals"`
+	MinPacketSize           uint64                   `yaml:"min_packet_size"`
+	MaxPacketSize           uint64                   `yaml:"max_packet_size"`
+	KeyMode                 string                   `yaml:"key_mode"`
+	Bidirectional           bool                     `yaml:"bidirectional"`
+	ServerAddr              string                   `yaml:"server_addr"`
+	APIKey                  string                   `yaml:"api_key"`
+	PrometheusAddr          string                   `yaml:"prometheus_addr"`
+	DockerLabels            map[string]string        `yaml:"docker_labels"`
+	ServerIDSource          string                   `yaml:"server_id_source"`
+	PortEnvVar              string                   `yaml:"port_env_var"`
+	PortSpecSource          string                   `yaml:"port_spec_source"`
+	MetricLabels            map[string]string        `yaml:"metric_labels"`
+	MetricLabelMaxValues    int                      `yaml:"metric_label_max_values"`
+	Profiles                map[string]ProfileConfig `yaml:"profiles"`
+	ProfileSource           string                   `yaml:"profile_source"`
+	Kubernetes              KubernetesConfig         `yaml:"kubernetes"`
+	Agones                  AgonesConfig             `yaml:"agones"`
+	Static                  StaticConfig             `yaml:"static"`
+	Podman                  PodmanConfig             `yaml:"podman"`
+	Containerd              ContainerdConfig         `yaml:"containerd"`
+	Panel                   PanelConfig              `yaml:"panel"`
+	Calibration             CalibrationConfig        `yaml:"calibration"`
+	LogLevel                string                   `yaml:"log_level"`
+}
+
+type KubernetesConfig struct {
+	Kubeconfig       string `yaml:"kubeconfig"`
+	NodeName         string `yaml:"node_name"`
+	LabelSelector    string `yaml:"label_selector"`
+	ServerIDSource   string `yaml:"server_id_source"`
+	PortsAnnotation  string `yaml:"ports_annotation"`
+	NodePortServices bool   `yaml:"node_port_services"`
+}
+
+type AgonesConfig struct {
+	Namespace  string `yaml:"namespace"`
+	WriteBack  string `yaml:"write_back"`
+	Annotation string `yaml:"annotation"`
+	Counter    string `yaml:"counter"`
+}
+
+// ProfileConfig overrides the player estimation settings for one kind of
+// game. Unset settings inherit the global ones.
+type ProfileConfig struct {
+	Images                  []string       `yaml:"images"`
+	Strategy                string         `yaml:"strategy"`
+	PlayerActivityThreshold *time.Duration `yaml:"player_activity_threshold"`
+	MinPacketsThreshold     *uint64        `yaml:"min_packets_threshold"`
+	MinBytesThreshold       *uint64        `yaml:"min_bytes_threshold"`
+	MinPacketRate           *float64       `yaml:"min_packet_rate"`
+	RateIntervals           *int           `yaml:"rate_intervals"`
+	MinPacketSize           *uint64        `yaml:"min_packet_size"`
+	MaxPacketSize           *uint64        `yaml:"max_packet_size"`
+	KeyMode                 string         `yaml:"key_mode"`
+	Bidirectional           *bool          `yaml:"bidirectional"`
+}
+
+type PanelConfig struct {
+	URL      string        `yaml:"url"`
+	APIKey   string        `yaml:"api_key"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// CalibrationConfig enables calibration for the games, i.e. profiles,
+// listed in Games.
+type CalibrationConfig struct {
+	Host     string                       `yaml:"host"`
+	Interval time.Duration                `yaml:"interval"`
+	Timeout  time.Duration                `yaml:"timeout"`
+	Games    map[string]CalibrationTarget `yaml:"games"`
+}
+
+type CalibrationTarget struct {
+	Protocol string `yaml:"protocol"`
+	Password string `yaml:"password"`
+	Command  string `yaml:"command"`
+}
+
+type StaticConfig struct {
+	File string `yaml:"file"`
+}
+
+type PodmanConfig struct {
+	Socket string `yaml:"socket"`
+}
+
+type ContainerdConfig struct {
+	Address    string `yaml:"address"`
+	Namespace  string `yaml:"namespace"`
+	PortsLabel string `yaml:"ports_label"`
+}
+
+// StringList accepts a single string as well as a list of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
 }
 
 func Load(path string) (*Config, error) {
 	cfg := &Config{
 		Interface:               "eth0",
 		EBPFMapSize:             100000,
+		EBPFPortsMapSize:        1000,
+		PinPath:                 ebpf.DefaultPinPath,
+		PinIncompatible:         "replace",
+		Discovery:               StringList{"docker"},
 		DiscoveryInterval:       30 * time.Second,
 		MetricsInterval:         30 * time.Second,
 		PlayerActivityThreshold: 5 * time.Minute,
+		FlowRetention:           15 * time.Minute,
+		GCInterval:              time.Minute,
 		MinPacketsThreshold:     50,
 		MinBytesThreshold:       1000,
+		Strategy:                "threshold",
+		MinPacketRate:           5,
+		RateIntervals:           3,
+		MinPacketSize:           32,
+		MaxPacketSize:           400,
+		KeyMode:                 "ip",
+		AttachMode:              "tcx",
+		TCPriority:              1,
+		TCHandle:                1,
+		TCConflict:              "refuse",
+		VXLANPort:               ebpf.DefaultVXLANPort,
 		ServerAddr:              ":8080",
 		DockerLabels:            make(map[string]string),
 		ServerIDSource:          "hostname",
 		PortEnvVar:              "",
-		LogLevel:                "info",
+		PortSpecSource:          "label:flowlens.ports",
+		MetricLabelMaxValues:    100,
+		ProfileSource:           "label:flowlens.profile",
+		Kubernetes: KubernetesConfig{
+			NodeName:         os.Getenv("NODE_NAME"),
+			ServerIDSource:   "name",
+			PortsAnnotation:  "flowlens.io/ports",
+			NodePortServices: true,
+		},
+		Agones: AgonesConfig{
+			Annotation: "flowlens.io/players",
+			Counter:    "players",
+		},
+		Panel: PanelConfig{
+			CacheTTL: 5 * time.Minute,
+		},
+		Calibration: CalibrationConfig{
+			Host:     "127.0.0.1",
+			Interval: time.Minute,
+			Timeout:  3 * time.Second,
+		},
+		Podman: PodmanConfig{
+			Socket: docker.DefaultPodmanSocket,
+		},
+		Containerd: ContainerdConfig{
+			Address:    containerd.DefaultAddress,
+			Namespace:  containerd.DefaultNamespace,
+			PortsLabel: containerd.DefaultPortsLabel,
+		},
+		LogLevel: "info",
 	}
 
 	data, err := os.ReadFile(path)
@@ -54,3 +221,12 @@ func Load(path string) (*Config, error) {
 
 	return cfg, nil
 }
+
+// InterfaceList returns the interfaces to monitor. Interfaces takes
+// precedence over the single Interface option.
+func (c *Config) InterfaceList() []string {
+	if len(c.Interfaces) > 0 {
+		return c.Interfaces
+	}
+	return []string{c.Interface}
+}
@@ -0,0 +1,422 @@
+package agones
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var GameServerResource = schema.GroupVersionResource{
+	Group:    "agones.dev",
+	Version:  "v1",
+	Resource: "gameservers",
+}
+
+const (
+	LabelState = "agones_state"
+	LabelFleet = "agones_fleet"
+
+	fleetLabel = "agones.dev/fleet"
+)
+
+// WriteBack selects where estimated player counts are written to.
+type WriteBack string
+
+const (
+	WriteBackNone       WriteBack = ""
+	WriteBackAnnotation WriteBack = "annotation"
+	WriteBackStatus     WriteBack = "status"
+)
+
+// activeStates are the states in which a GameServer has its ports allocated
+// and accepts players.
+var activeStates = map[string]bool{
+	"Ready":     true,
+	"Reserved":  true,
+	"Allocated": true,
+}
+
+type Options struct {
+	// NodeName restricts discovery to GameServers scheduled on this node.
+	NodeName string
+	// Namespace to watch. Empty watches all namespaces, in which case server
+	// IDs are namespace/name as names are only unique per namespace.
+	Namespace     string
+	LabelSelector string
+	WriteBack     WriteBack
+	// Annotation receives the player count with WriteBackAnnotation.
+	Annotation string
+	// Counter is the status counter updated with WriteBackStatus. It must be
+	// declared in the GameServer spec.
+	Counter string
+	// LabelSources sets additional labels reported for each server, keyed by
+	// label name. A source is name, namespace, label:KEY or annotation:KEY.
+	LabelSources map[string]string
+	// ProfileSource is where a server's threshold profile name is read from,
+	// label:KEY or annotation:KEY.
+	ProfileSource  string
+	ResyncInterval time.Duration
+}
+
+// Watcher discovers game servers from the Agones GameServers on one node and
+// optionally reports player counts back to them.
+type Watcher struct {
+	client dynamic.Interface
+	opts   Options
+
+	mu          sync.Mutex
+	gameServers map[string]*unstructured.Unstructured
+	written     map[string]int
+	counts      chan map[string]int
+}
+
+func NewWatcher(client dynamic.Interface, opts Options) *Watcher {
+	return &Watcher{
+		client:      client,
+		opts:        opts,
+		gameServers: make(map[string]*unstructured.Unstructured),
+		written:     make(map[string]int),
+		counts:      make(chan map[string]int, 1),
+	}
+}
+
+func (w *Watcher) Name() string {
+	return "agones"
+}
+
+// Run sends the full server set on updates every time a GameServer changes,
+// and writes back player counts passed to ReportPlayers.
+func (w *Watcher) Run(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client, w.opts.ResyncInterval, w.opts.Namespace,
+		func(o *metav1.ListOptions) {
+			o.LabelSelector = w.opts.LabelSelector
+		},
+	)
+	informer := factory.ForResource(GameServerResource)
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(any) { notify() },
+		UpdateFunc: func(oldObj, newObj any) {
+			if !w.ownWrite(oldObj, newObj) {
+				notify()
+			}
+		},
+		DeleteFunc: func(any) { notify() },
+	})
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		return
+	}
+
+	if w.opts.WriteBack != WriteBackNone {
+		go w.writeLoop(ctx)
+	}
+
+	for {
+		objs, err := informer.Lister().List(labels.Everything())
+		if err != nil {
+			slog.Error("Error listing GameServers", "error", err)
+		} else {
+			select {
+			case updates <- w.servers(objs):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Watcher) servers(objs []runtime.Object) []discovery.ServerMetadata {
+	servers := make([]discovery.ServerMetadata, 0, len(objs))
+	gameServers := make(map[string]*unstructured.Unstructured, len(objs))
+
+	for _, obj := range objs {
+		gs, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		srv, ok := w.serverFromGameServer(gs)
+		if !ok {
+			continue
+		}
+		servers = append(servers, srv)
+		gameServers[srv.ServerID] = gs
+	}
+
+	w.mu.Lock()
+	w.gameServers = gameServers
+	w.mu.Unlock()
+
+	return servers
+}
+
+func (w *Watcher) serverFromGameServer(gs *unstructured.Unstructured) (discovery.ServerMetadata, bool) {
+	if gs.GetDeletionTimestamp() != nil {
+		return discovery.ServerMetadata{}, false
+	}
+
+	// Custom resources only support field selectors on metadata, so the node
+	// is matched here.
+	node, _, _ := unstructured.NestedString(gs.Object, "status", "nodeName")
+	if node != w.opts.NodeName {
+		return discovery.ServerMetadata{}, false
+	}
+
+	state, _, _ := unstructured.NestedString(gs.Object, "status", "state")
+	if !activeStates[state] {
+		return discovery.ServerMetadata{}, false
+	}
+
+	statusPorts, _, _ := unstructured.NestedSlice(gs.Object, "status", "ports")
+	var ports []discovery.PortRange
+	var gamePort int
+	for _, p := range statusPorts {
+		entry, ok := p.(map[string]any)
+		if !ok {
+			continue
+		}
+		port, _, _ := unstructured.NestedInt64(entry, "port")
+		if port <= 0 || port > 65535 {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(entry, "name")
+		role := discovery.RoleFromName(name)
+		if role == discovery.RoleGame && gamePort == 0 {
+			gamePort = int(port)
+		}
+		ports = append(ports, discovery.PortRange{Start: int(port), End: int(port), Role: role})
+	}
+	if len(ports) == 0 {
+		return discovery.ServerMetadata{}, false
+	}
+
+	srvLabels := discovery.ResolveLabels(w.opts.LabelSources, func(source string) (string, bool) {
+		return gameServerSource(gs, source)
+	})
+	if srvLabels == nil {
+		srvLabels = make(map[string]string, 2)
+	}
+	srvLabels[LabelState] = state
+	if fleet := gs.GetLabels()[fleetLabel]; fleet != "" {
+		srvLabels[LabelFleet] = fleet
+	}
+
+	var image string
+	containers, _, _ := unstructured.NestedSlice(gs.Object, "spec", "template", "spec", "containers")
+	if len(containers) > 0 {
+		if c, ok := containers[0].(map[string]any); ok {
+			image, _, _ = unstructured.NestedString(c, "image")
+		}
+	}
+	profile, _ := gameServerSource(gs, w.opts.ProfileSource)
+
+	return discovery.ServerMetadata{
+		ServerID:      w.serverID(gs),
+		GamePort:      gamePort,
+		Ports:         ports,
+		ContainerID:   string(gs.GetUID()),
+		ContainerName: gs.GetNamespace() + "/" + gs.GetName(),
+		Image:         image,
+		Profile:       profile,
+		Labels:        srvLabels,
+		LastUpdated:   time.Now(),
+	}, true
+}
+
+func (w *Watcher) serverID(gs *unstructured.Unstructured) string {
+	if w.opts.Namespace == "" {
+		return gs.GetNamespace() + "/" + gs.GetName()
+	}
+	return gs.GetName()
+}
+
+func gameServerSource(gs *unstructured.Unstructured, source string) (string, bool) {
+	switch {
+	case source == "name":
+		return gs.GetName(), true
+	case source == "namespace":
+		return gs.GetNamespace(), true
+	case strings.HasPrefix(source, "label:"):
+		val, ok := gs.GetLabels()[strings.TrimPrefix(source, "label:")]
+		return val, ok
+	case strings.HasPrefix(source, "annotation:"):
+		val, ok := gs.GetAnnotations()[strings.TrimPrefix(source, "annotation:")]
+		return val, ok
+	}
+	return "", false
+}
+
+// ReportPlayers queues the player counts in stats for writing back to their
+// GameServers. It never blocks; only the latest counts are kept.
+func (w *Watcher) ReportPlayers(stats []estimator.ServerPlayerStats) {
+	if w.opts.WriteBack == WriteBackNone {
+		return
+	}
+
+	counts := make(map[string]int, len(stats))
+	for _, stat := range stats {
+		counts[stat.ServerID] = stat.ActivePlayers
+	}
+
+	select {
+	case <-w.counts:
+	default:
+	}
+	w.counts <- counts
+}
+
+func (w *Watcher) writeLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case counts := <-w.counts:
+			w.writeCounts(ctx, counts)
+		}
+	}
+}
+
+// writeCounts patches every known GameServer whose count differs from the
+// one it holds. Servers missing from counts had no traffic and get zero.
+func (w *Watcher) writeCounts(ctx context.Context, counts map[string]int) {
+	w.mu.Lock()
+	gameServers := w.gameServers
+	w.mu.Unlock()
+
+	for id, gs := range gameServers {
+		count := counts[id]
+		if last, ok := w.written[id]; ok && last == count {
+			continue
+		}
+		if current, ok := w.current(gs); ok && current == count {
+			w.written[id] = count
+			continue
+		}
+
+		if err := w.patch(ctx, gs, count); err != nil {
+			slog.Error("Error writing player count to GameServer", "gameserver", gs.GetNamespace()+"/"+gs.GetName(), "error", err)
+			continue
+		}
+		w.written[id] = count
+	}
+
+	for id := range w.written {
+		if _, ok := gameServers[id]; !ok {
+			delete(w.written, id)
+		}
+	}
+}
+
+// current returns the player count a GameServer already holds, so a restart
+// does not rewrite unchanged counts.
+func (w *Watcher) current(gs *unstructured.Unstructured) (int, bool) {
+	switch w.opts.WriteBack {
+	case WriteBackAnnotation:
+		v, ok := gs.GetAnnotations()[w.opts.Annotation]
+		if !ok {
+			return 0, false
+		}
+		count, err := strconv.Atoi(v)
+		return count, err == nil
+	case WriteBackStatus:
+		count, found, err := unstructured.NestedInt64(gs.Object, "status", "counters", w.opts.Counter, "count")
+		return int(count), found && err == nil
+	}
+	return 0, false
+}
+
+// ownWrite reports whether a GameServer update only changed what the write
+// back sets, which does not change the discovered server.
+func (w *Watcher) ownWrite(oldObj, newObj any) bool {
+	if w.opts.WriteBack == WriteBackNone {
+		return false
+	}
+	oldGS, ok1 := oldObj.(*unstructured.Unstructured)
+	newGS, ok2 := newObj.(*unstructured.Unstructured)
+	if !ok1 || !ok2 {
+		return false
+	}
+	return equality.Semantic.DeepEqual(w.withoutWrite(oldGS), w.withoutWrite(newGS))
+}
+
+func (w *Watcher) withoutWrite(gs *unstructured.Unstructured) map[string]any {
+	obj := gs.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	switch w.opts.WriteBack {
+	case WriteBackAnnotation:
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations", w.opts.Annotation)
+		if len(obj.GetAnnotations()) == 0 {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+		}
+	case WriteBackStatus:
+		unstructured.RemoveNestedField(obj.Object, "status", "counters", w.opts.Counter, "count")
+	}
+	return obj.Object
+}
+
+func (w *Watcher) patch(ctx context.Context, gs *unstructured.Unstructured, count int) error {
+	var patch map[string]any
+	switch w.opts.WriteBack {
+	case WriteBackAnnotation:
+		patch = map[string]any{
+			"metadata": map[string]any{
+				"annotations": map[string]any{w.opts.Annotation: strconv.Itoa(count)},
+			},
+		}
+	case WriteBackStatus:
+		if _, found, _ := unstructured.NestedMap(gs.Object, "status", "counters", w.opts.Counter); !found {
+			return fmt.Errorf("counter %q is not declared on the GameServer", w.opts.Counter)
+		}
+		patch = map[string]any{
+			"status": map[string]any{
+				"counters": map[string]any{
+					w.opts.Counter: map[string]any{"count": count},
+				},
+			},
+		}
+	default:
+		return fmt.Errorf("unknown write back mode %q", w.opts.WriteBack)
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to encode patch: %w", err)
+	}
+
+	_, err = w.client.Resource(GameServerResource).Namespace(gs.GetNamespace()).
+		Patch(ctx, gs.GetName(), types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,276 @@
+package agones
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testNode = "node-a"
+
+func gameServer(namespace, name, node, state string, mutate ...func(map[string]any)) *unstructured.Unstructured {
+	obj := map[string]any{
+		"apiVersion": "agones.dev/v1",
+		"kind":       "GameServer",
+		"metadata": map[string]any{
+			"namespace": namespace,
+			"name":      name,
+			"uid":       "uid-" + name,
+			"labels":    map[string]any{"agones.dev/fleet": "survival", "game": "rust"},
+		},
+		"spec": map[string]any{
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []any{
+						map[string]any{"name": "game", "image": "example/rust:1"},
+					},
+				},
+			},
+		},
+		"status": map[string]any{
+			"nodeName": node,
+			"state":    state,
+			"ports": []any{
+				map[string]any{"name": "default", "port": int64(7001)},
+				map[string]any{"name": "query-steam", "port": int64(7002)},
+				map[string]any{"name": "rcon", "port": int64(7003)},
+			},
+		},
+	}
+	for _, m := range mutate {
+		m(obj)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestServerFromGameServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		gs        *unstructured.Unstructured
+		wantOK    bool
+		wantID    string
+		wantPorts []discovery.PortRange
+		wantGame  int
+	}{
+		{
+			name:   "allocated on this node",
+			gs:     gameServer("games", "rust-1", testNode, "Allocated"),
+			wantOK: true,
+			wantID: "games/rust-1",
+			wantPorts: []discovery.PortRange{
+				{Start: 7001, End: 7001, Role: discovery.RoleGame},
+				{Start: 7002, End: 7002, Role: discovery.RoleQuery},
+				{Start: 7003, End: 7003, Role: discovery.RoleRCON},
+			},
+			wantGame: 7001,
+		},
+		{
+			name:      "single namespace keeps the plain name",
+			namespace: "games",
+			gs:        gameServer("games", "rust-1", testNode, "Ready"),
+			wantOK:    true,
+			wantID:    "rust-1",
+			wantPorts: []discovery.PortRange{
+				{Start: 7001, End: 7001, Role: discovery.RoleGame},
+				{Start: 7002, End: 7002, Role: discovery.RoleQuery},
+				{Start: 7003, End: 7003, Role: discovery.RoleRCON},
+			},
+			wantGame: 7001,
+		},
+		{
+			name: "invalid ports are skipped",
+			gs: gameServer("games", "rust-1", testNode, "Reserved", func(obj map[string]any) {
+				obj["status"].(map[string]any)["ports"] = []any{
+					map[string]any{"name": "default", "port": int64(0)},
+					map[string]any{"name": "default", "port": int64(70000)},
+					"not a port",
+					map[string]any{"name": "voice", "port": int64(9987)},
+				}
+			}),
+			wantOK:    true,
+			wantID:    "games/rust-1",
+			wantPorts: []discovery.PortRange{{Start: 9987, End: 9987, Role: discovery.RoleVoice}},
+		},
+		{
+			name: "other node",
+			gs:   gameServer("games", "rust-1", "node-b", "Allocated"),
+		},
+		{
+			name: "not yet ready",
+			gs:   gameServer("games", "rust-1", testNode, "Scheduled"),
+		},
+		{
+			name: "being deleted",
+			gs: gameServer("games", "rust-1", testNode, "Allocated", func(obj map[string]any) {
+				obj["metadata"].(map[string]any)["deletionTimestamp"] = "2025-01-01T00:00:00Z"
+			}),
+		},
+		{
+			name: "no ports",
+			gs: gameServer("games", "rust-1", testNode, "Allocated", func(obj map[string]any) {
+				delete(obj["status"].(map[string]any), "ports")
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWatcher(nil, Options{
+				NodeName:      testNode,
+				Namespace:     tt.namespace,
+				LabelSources:  map[string]string{"game": "label:game"},
+				ProfileSource: "label:game",
+			})
+
+			srv, ok := w.serverFromGameServer(tt.gs)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			if srv.ServerID != tt.wantID {
+				t.Errorf("ServerID = %q, want %q", srv.ServerID, tt.wantID)
+			}
+			if srv.GamePort != tt.wantGame {
+				t.Errorf("GamePort = %d, want %d", srv.GamePort, tt.wantGame)
+			}
+			if len(srv.Ports) != len(tt.wantPorts) {
+				t.Fatalf("Ports = %v, want %v", srv.Ports, tt.wantPorts)
+			}
+			for i := range tt.wantPorts {
+				if srv.Ports[i] != tt.wantPorts[i] {
+					t.Fatalf("Ports = %v, want %v", srv.Ports, tt.wantPorts)
+				}
+			}
+			if srv.Image != "example/rust:1" || srv.Profile != "rust" {
+				t.Errorf("Image, Profile = %q, %q, want example/rust:1, rust", srv.Image, srv.Profile)
+			}
+			state, _, _ := unstructured.NestedString(tt.gs.Object, "status", "state")
+			if srv.Labels[LabelState] != state || srv.Labels[LabelFleet] != "survival" || srv.Labels["game"] != "rust" {
+				t.Errorf("Labels = %v", srv.Labels)
+			}
+		})
+	}
+}
+
+func TestServerIDsAcrossNamespaces(t *testing.T) {
+	w := NewWatcher(nil, Options{NodeName: testNode})
+	servers := w.servers([]runtime.Object{
+		gameServer("eu", "rust-1", testNode, "Allocated"),
+		gameServer("us", "rust-1", testNode, "Allocated"),
+	})
+	if len(servers) != 2 || servers[0].ServerID == servers[1].ServerID {
+		t.Fatalf("servers %v and %v share an ID", servers[0].ServerID, servers[1].ServerID)
+	}
+	if len(w.gameServers) != 2 {
+		t.Fatalf("tracked %d GameServers, want 2", len(w.gameServers))
+	}
+}
+
+func TestWriteBackSkipsUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		writeBack WriteBack
+		mutate    func(map[string]any)
+	}{
+		{
+			name:      "annotation",
+			writeBack: WriteBackAnnotation,
+			mutate: func(obj map[string]any) {
+				obj["metadata"].(map[string]any)["annotations"] = map[string]any{"flowlens.io/players": "3"}
+			},
+		},
+		{
+			name:      "status counter",
+			writeBack: WriteBackStatus,
+			mutate: func(obj map[string]any) {
+				obj["status"].(map[string]any)["counters"] = map[string]any{
+					"players": map[string]any{"count": int64(3), "capacity": int64(10)},
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := gameServer("games", "rust-1", testNode, "Allocated", tt.mutate)
+			client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{GameServerResource: "GameServerList"}, gs)
+
+			w := NewWatcher(client, Options{
+				NodeName:   testNode,
+				WriteBack:  tt.writeBack,
+				Annotation: "flowlens.io/players",
+				Counter:    "players",
+			})
+			w.servers([]runtime.Object{gs})
+			ctx := context.Background()
+
+			patches := func() int {
+				n := 0
+				for _, a := range client.Actions() {
+					if a.GetVerb() == "patch" {
+						n++
+					}
+				}
+				return n
+			}
+
+			// The GameServer already holds 3, e.g. from before a restart.
+			w.writeCounts(ctx, map[string]int{"games/rust-1": 3})
+			if n := patches(); n != 0 {
+				t.Fatalf("%d patches for an unchanged count, want 0", n)
+			}
+
+			w.writeCounts(ctx, map[string]int{"games/rust-1": 5})
+			if n := patches(); n != 1 {
+				t.Fatalf("%d patches after a change, want 1", n)
+			}
+
+			w.writeCounts(ctx, map[string]int{"games/rust-1": 5})
+			if n := patches(); n != 1 {
+				t.Fatalf("%d patches after repeating the count, want 1", n)
+			}
+
+			got, err := client.Resource(GameServerResource).Namespace("games").Get(ctx, "rust-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current, ok := w.current(got); !ok || current != 5 {
+				t.Errorf("written count = %d, %v, want 5", current, ok)
+			}
+		})
+	}
+}
+
+func TestOwnWrite(t *testing.T) {
+	w := NewWatcher(nil, Options{NodeName: testNode, WriteBack: WriteBackAnnotation, Annotation: "flowlens.io/players"})
+
+	old := gameServer("games", "rust-1", testNode, "Allocated")
+	written := old.DeepCopy()
+	written.SetAnnotations(map[string]string{"flowlens.io/players": "4"})
+	written.SetResourceVersion("2")
+	if !w.ownWrite(old, written) {
+		t.Error("annotation write counted as a change")
+	}
+
+	shutdown := written.DeepCopy()
+	_ = unstructured.SetNestedField(shutdown.Object, "Shutdown", "status", "state")
+	if w.ownWrite(written, shutdown) {
+		t.Error("state change counted as an own write")
+	}
+
+	w.opts.WriteBack = WriteBackNone
+	if w.ownWrite(old, written) {
+		t.Error("own write without write back")
+	}
+}
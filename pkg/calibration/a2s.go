@@ -0,0 +1,98 @@
+package calibration
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	a2sInfo      = 0x54
+	a2sInfoReply = 0x49
+	a2sChallenge = 0x41
+)
+
+var a2sHeader = []byte{0xff, 0xff, 0xff, 0xff}
+
+// QueryA2S asks a Source engine server at addr for its player count with
+// A2S_INFO over UDP. Bots are not counted, since they send no traffic.
+func QueryA2S(ctx context.Context, addr string) (int, error) {
+	conn, err := dial(ctx, "udp", addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	request := append(append([]byte{}, a2sHeader...), a2sInfo)
+	request = append(request, "Source Engine Query\x00"...)
+
+	buf := make([]byte, 1400)
+	// Servers since 2020 answer the first request with a challenge that has
+	// to be appended to a second one.
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return 0, fmt.Errorf("failed to send A2S_INFO: %w", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read A2S_INFO reply: %w", err)
+		}
+		reply := buf[:n]
+		if len(reply) < 5 || !bytes.Equal(reply[:4], a2sHeader) {
+			return 0, errors.New("malformed A2S reply")
+		}
+
+		switch reply[4] {
+		case a2sChallenge:
+			if len(reply) < 9 {
+				return 0, errors.New("malformed A2S challenge")
+			}
+			request = append(request[:25], reply[5:9]...)
+		case a2sInfoReply:
+			return parseA2SInfo(reply[5:])
+		default:
+			return 0, fmt.Errorf("unexpected A2S reply type 0x%02x", reply[4])
+		}
+	}
+	return 0, errors.New("server kept sending A2S challenges")
+}
+
+// parseA2SInfo reads the player and bot counts out of an A2S_INFO payload:
+// protocol, name, map, folder, game, app ID, players, max players, bots.
+func parseA2SInfo(payload []byte) (int, error) {
+	r := bytes.NewReader(payload)
+	if _, err := r.ReadByte(); err != nil {
+		return 0, errors.New("truncated A2S_INFO reply")
+	}
+	for range 4 {
+		if _, err := readCString(r); err != nil {
+			return 0, errors.New("truncated A2S_INFO reply")
+		}
+	}
+	var fields struct {
+		AppID      uint16
+		Players    uint8
+		MaxPlayers uint8
+		Bots       uint8
+	}
+	if err := binary.Read(r, binary.LittleEndian, &fields); err != nil {
+		return 0, errors.New("truncated A2S_INFO reply")
+	}
+	return max(int(fields.Players)-int(fields.Bots), 0), nil
+}
+
+func readCString(r *bytes.Reader) (string, error) {
+	var s []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(s), nil
+		}
+		s = append(s, b)
+	}
+}
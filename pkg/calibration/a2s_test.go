@@ -0,0 +1,120 @@
+package calibration
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+var a2sRequest = append([]byte{0xff, 0xff, 0xff, 0xff, a2sInfo}, "Source Engine Query\x00"...)
+
+func a2sInfoPayload(players, maxPlayers, bots byte) []byte {
+	reply := append([]byte{0xff, 0xff, 0xff, 0xff, a2sInfoReply, 17}, "FlowLens Test\x00de_dust2\x00csgo\x00Counter-Strike\x00"...)
+	return append(reply, 0xda, 0x02, players, maxPlayers, bots)
+}
+
+// serveA2S answers every request on a local UDP port with the next reply,
+// after checking it against the expected request.
+func serveA2S(t *testing.T, exchanges ...[2][]byte) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		pc.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1400)
+		for _, ex := range exchanges {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if want := ex[0]; !bytes.Equal(buf[:n], want) {
+				t.Errorf("request = %q, want %q", buf[:n], want)
+			}
+			pc.WriteTo(ex[1], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestQueryA2S(t *testing.T) {
+	challenge := []byte{0x12, 0x34, 0x56, 0x78}
+	challengeReply := append([]byte{0xff, 0xff, 0xff, 0xff, a2sChallenge}, challenge...)
+	challenged := append(append([]byte{}, a2sRequest...), challenge...)
+
+	tests := []struct {
+		name      string
+		exchanges [][2][]byte
+		want      int
+		wantErr   bool
+	}{
+		{
+			name:      "direct reply",
+			exchanges: [][2][]byte{{a2sRequest, a2sInfoPayload(12, 32, 0)}},
+			want:      12,
+		},
+		{
+			name: "challenge round trip",
+			exchanges: [][2][]byte{
+				{a2sRequest, challengeReply},
+				{challenged, a2sInfoPayload(7, 32, 0)},
+			},
+			want: 7,
+		},
+		{
+			name:      "bots are not counted",
+			exchanges: [][2][]byte{{a2sRequest, a2sInfoPayload(10, 32, 4)}},
+			want:      6,
+		},
+		{
+			name:      "more bots than players",
+			exchanges: [][2][]byte{{a2sRequest, a2sInfoPayload(1, 32, 3)}},
+			want:      0,
+		},
+		{
+			name: "repeated challenge",
+			exchanges: [][2][]byte{
+				{a2sRequest, challengeReply},
+				{challenged, challengeReply},
+			},
+			wantErr: true,
+		},
+		{
+			name:      "truncated challenge",
+			exchanges: [][2][]byte{{a2sRequest, challengeReply[:7]}},
+			wantErr:   true,
+		},
+		{
+			name:      "truncated info",
+			exchanges: [][2][]byte{{a2sRequest, a2sInfoPayload(12, 32, 0)[:30]}},
+			wantErr:   true,
+		},
+		{
+			name:      "bad header",
+			exchanges: [][2][]byte{{a2sRequest, []byte{0xfe, 0xff, 0xff, 0xff, a2sInfoReply}}},
+			wantErr:   true,
+		},
+		{
+			name:      "unexpected type",
+			exchanges: [][2][]byte{{a2sRequest, []byte{0xff, 0xff, 0xff, 0xff, 0x44}}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveA2S(t, tt.exchanges...)
+			got, err := QueryA2S(testContext(t), addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("players = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
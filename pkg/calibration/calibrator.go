@@ -0,0 +1,311 @@
+package calibration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+)
+
+const (
+	ProtocolA2S  = "a2s"
+	ProtocolSLP  = "slp"
+	ProtocolRCON = "rcon"
+)
+
+// Target says how the servers of one game are asked for their player count.
+// Command is the RCON command, "status" by default.
+type Target struct {
+	Protocol string
+	Password string
+	Command  string
+}
+
+// Estimates is what the calibrator reads from the estimator to suggest
+// thresholds. *estimator.Engine implements it.
+type Estimates interface {
+	Clients(serverID string) []estimator.ClientActivity
+	Params(serverID string) estimator.Params
+}
+
+// Calibrator periodically asks game servers for their real player count and
+// compares it with the estimate. Servers are grouped into games by their
+// profile; only games with a Target are queried.
+type Calibrator struct {
+	host     string
+	interval time.Duration
+	timeout  time.Duration
+	targets  map[string]Target
+
+	mu       sync.Mutex
+	servers  []server
+	readings map[string]reading
+	perSrv   map[string]*serverState
+	perGame  map[string]*gameState
+}
+
+type server struct {
+	id     string
+	game   string
+	addr   string
+	target Target
+}
+
+type reading struct {
+	players int
+	at      time.Time
+}
+
+type serverState struct {
+	game     string
+	protocol string
+	errors   ErrorStats
+}
+
+// NewCalibrator queries servers on host, where their ports are published,
+// every interval. Each query gives up after timeout.
+func NewCalibrator(host string, interval, timeout time.Duration, targets map[string]Target) (*Calibrator, error) {
+	checked := make(map[string]Target, len(targets))
+	for game, t := range targets {
+		switch t.Protocol {
+		case ProtocolA2S, ProtocolSLP:
+		case ProtocolRCON:
+			if t.Command == "" {
+				t.Command = "status"
+			}
+		default:
+			return nil, fmt.Errorf("invalid calibration protocol %q for %s, expected a2s, slp or rcon", t.Protocol, game)
+		}
+		checked[game] = t
+	}
+
+	return &Calibrator{
+		host:     host,
+		interval: interval,
+		timeout:  timeout,
+		targets:  checked,
+		readings: make(map[string]reading),
+		perSrv:   make(map[string]*serverState),
+		perGame:  make(map[string]*gameState),
+	}, nil
+}
+
+// SetServers replaces the servers to calibrate. games maps server IDs to the
+// game, i.e. profile, they belong to.
+func (c *Calibrator) SetServers(servers []discovery.ServerMetadata, games map[string]string) {
+	var selected []server
+	for _, srv := range servers {
+		game := games[srv.ServerID]
+		target, ok := c.targets[game]
+		if !ok {
+			continue
+		}
+		port := queryPort(srv, target.Protocol)
+		if port == 0 {
+			slog.Debug("No port to calibrate server on", "server_id", srv.ServerID, "protocol", target.Protocol)
+			continue
+		}
+		selected = append(selected, server{
+			id:     srv.ServerID,
+			game:   game,
+			addr:   net.JoinHostPort(c.host, strconv.Itoa(port)),
+			target: target,
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.servers = selected
+	known := make(map[string]bool, len(selected))
+	for _, srv := range selected {
+		known[srv.id] = true
+	}
+	for id := range c.perSrv {
+		if !known[id] {
+			delete(c.perSrv, id)
+			delete(c.readings, id)
+		}
+	}
+}
+
+// queryPort picks the port a protocol is spoken on: A2S on the query port
+// if the server has one, RCON only on an RCON port, anything else on the
+// game port.
+func queryPort(srv discovery.ServerMetadata, protocol string) int {
+	role := discovery.RoleGame
+	switch protocol {
+	case ProtocolA2S:
+		role = discovery.RoleQuery
+	case ProtocolRCON:
+		role = discovery.RoleRCON
+	}
+	for _, r := range srv.Ports {
+		if r.Role == role {
+			return r.Start
+		}
+	}
+	if protocol == ProtocolRCON {
+		return 0
+	}
+	return srv.GamePort
+}
+
+// Run queries every server each interval until ctx is cancelled.
+func (c *Calibrator) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.queryAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Calibrator) queryAll(ctx context.Context) {
+	c.mu.Lock()
+	servers := c.servers
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			qctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			players, err := query(qctx, srv)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if err != nil {
+				slog.Debug("Error querying player count", "server_id", srv.id, "protocol", srv.target.Protocol, "address", srv.addr, "error", err)
+				c.server(srv).errors.QueryErrors++
+				c.game(srv.game).errors.QueryErrors++
+				return
+			}
+			c.readings[srv.id] = reading{players: players, at: time.Now()}
+		}()
+	}
+	wg.Wait()
+}
+
+func query(ctx context.Context, srv server) (int, error) {
+	switch srv.target.Protocol {
+	case ProtocolA2S:
+		return QueryA2S(ctx, srv.addr)
+	case ProtocolSLP:
+		return QuerySLP(ctx, srv.addr)
+	default:
+		return QueryRCON(ctx, srv.addr, srv.target.Password, srv.target.Command)
+	}
+}
+
+func (c *Calibrator) server(srv server) *serverState {
+	st := c.perSrv[srv.id]
+	if st == nil {
+		st = &serverState{game: srv.game, protocol: srv.target.Protocol}
+		c.perSrv[srv.id] = st
+	}
+	return st
+}
+
+func (c *Calibrator) game(name string) *gameState {
+	gs := c.perGame[name]
+	if gs == nil {
+		gs = &gameState{}
+		c.perGame[name] = gs
+	}
+	return gs
+}
+
+// Record pairs every player count queried since the last call with the
+// matching estimate in stats and returns the latest queried count of each
+// server by ID. Servers missing from stats had no traffic and are estimated
+// at zero.
+func (c *Calibrator) Record(stats []estimator.ServerPlayerStats, est Estimates) map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	estimated := make(map[string]int, len(stats))
+	for _, stat := range stats {
+		estimated[stat.ServerID] = stat.ActivePlayers
+	}
+
+	for _, srv := range c.servers {
+		r, ok := c.readings[srv.id]
+		if !ok {
+			continue
+		}
+		delete(c.readings, srv.id)
+		// A reading that has not been paired for two intervals describes
+		// a different moment than the current estimate.
+		if time.Since(r.at) > 2*c.interval {
+			continue
+		}
+
+		c.server(srv).errors.add(estimated[srv.id], r.players, r.at)
+		gs := c.game(srv.game)
+		gs.errors.add(estimated[srv.id], r.players, r.at)
+		gs.observe(est.Clients(srv.id), est.Params(srv.id), r.players)
+	}
+
+	actual := make(map[string]int, len(c.perSrv))
+	for id, st := range c.perSrv {
+		if st.errors.Samples > 0 {
+			actual[id] = st.errors.LastActual
+		}
+	}
+	return actual
+}
+
+// Report is the calibration state of every server and game.
+type Report struct {
+	Servers map[string]ServerReport
+	Games   map[string]GameReport
+}
+
+type ServerReport struct {
+	Game     string
+	Protocol string
+	Errors   ErrorStats
+}
+
+// GameReport holds the errors of all servers of a game and, once Samples
+// reaches minSuggestionSamples, the thresholds that would have matched the
+// queried counts best. Current is what the game's servers used when last
+// sampled.
+type GameReport struct {
+	Errors    ErrorStats
+	Current   estimator.Params
+	Samples   int
+	Suggested *Suggestion
+}
+
+func (c *Calibrator) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := Report{
+		Servers: make(map[string]ServerReport, len(c.perSrv)),
+		Games:   make(map[string]GameReport, len(c.perGame)),
+	}
+	for id, st := range c.perSrv {
+		report.Servers[id] = ServerReport{Game: st.game, Protocol: st.protocol, Errors: st.errors}
+	}
+	for name, gs := range c.perGame {
+		report.Games[name] = GameReport{Errors: gs.errors, Current: gs.current, Samples: len(gs.samples), Suggested: gs.suggest()}
+	}
+	return report
+}
@@ -0,0 +1,20 @@
+package calibration
+
+import (
+	"context"
+	"net"
+)
+
+// dial connects to addr and applies the deadline of ctx to every read and
+// write on the connection.
+func dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
@@ -0,0 +1,117 @@
+package calibration
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+const (
+	rconResponseValue = 0
+	rconExecCommand   = 2
+	rconAuthResponse  = 2
+	rconAuth          = 3
+
+	// maxRCONPacket is the largest packet servers send; longer output is
+	// split, but the player count is always in the first packet.
+	maxRCONPacket = 4096 + 10
+)
+
+// playerCount matches the player count in the output of Source and Rust
+// "status" ("players : 5 humans, 0 bots") and Minecraft "list" ("There are
+// 5 of a max of 20 players online").
+var playerCount = regexp.MustCompile(`(?i)players\s*:\s*(\d+)|there are (\d+) of a max`)
+
+// QueryRCON logs in to the Source RCON protocol at addr, which Minecraft and
+// Rust speak too, runs command and reads the player count from its output.
+func QueryRCON(ctx context.Context, addr, password, command string) (int, error) {
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if err := writeRCON(conn, 1, rconAuth, password); err != nil {
+		return 0, fmt.Errorf("failed to send RCON login: %w", err)
+	}
+	// Source servers send an empty response value ahead of the auth response.
+	for {
+		id, typ, _, err := readRCON(conn)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read RCON login response: %w", err)
+		}
+		if typ != rconAuthResponse {
+			continue
+		}
+		if id == -1 {
+			return 0, errors.New("RCON login rejected")
+		}
+		break
+	}
+
+	if err := writeRCON(conn, 2, rconExecCommand, command); err != nil {
+		return 0, fmt.Errorf("failed to send RCON command: %w", err)
+	}
+	for {
+		id, typ, body, err := readRCON(conn)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read RCON response: %w", err)
+		}
+		if id != 2 || typ != rconResponseValue {
+			continue
+		}
+		return parsePlayerCount(body)
+	}
+}
+
+func parsePlayerCount(output string) (int, error) {
+	m := playerCount.FindStringSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("no player count in RCON output %q", truncate(output, 80))
+	}
+	n := m[1]
+	if n == "" {
+		n = m[2]
+	}
+	return strconv.Atoi(n)
+}
+
+func writeRCON(conn net.Conn, id, typ int32, body string) error {
+	packet := make([]byte, 12, 14+len(body))
+	binary.LittleEndian.PutUint32(packet[0:], uint32(10+len(body)))
+	binary.LittleEndian.PutUint32(packet[4:], uint32(id))
+	binary.LittleEndian.PutUint32(packet[8:], uint32(typ))
+	packet = append(packet, body...)
+	packet = append(packet, 0, 0)
+	_, err := conn.Write(packet)
+	return err
+}
+
+func readRCON(conn net.Conn) (int32, int32, string, error) {
+	var size int32
+	if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	if size < 10 || size > maxRCONPacket {
+		return 0, 0, "", fmt.Errorf("invalid RCON packet size %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		return 0, 0, "", err
+	}
+	id := int32(binary.LittleEndian.Uint32(packet[0:]))
+	typ := int32(binary.LittleEndian.Uint32(packet[4:]))
+	return id, typ, string(packet[8 : size-2]), nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,119 @@
+package calibration
+
+import (
+	"net"
+	"testing"
+)
+
+const rconPassword = "hunter2"
+
+// serveRCON logs a client in the way Source servers do, with an empty
+// RESPONSE_VALUE ahead of the auth response, and answers one command.
+func serveRCON(t *testing.T, output string) string {
+	t.Helper()
+	return serveTCP(t, func(conn net.Conn) {
+		id, typ, body, err := readRCON(conn)
+		if err != nil || typ != rconAuth {
+			t.Errorf("login = %d, %d, %v, want an auth packet", id, typ, err)
+			return
+		}
+		writeRCON(conn, id, rconResponseValue, "")
+		if body != rconPassword {
+			writeRCON(conn, -1, rconAuthResponse, "")
+			return
+		}
+		writeRCON(conn, id, rconAuthResponse, "")
+
+		id, typ, body, err = readRCON(conn)
+		if err != nil || typ != rconExecCommand || body != "status" {
+			t.Errorf("command = %d, %d, %q, %v, want status", id, typ, body, err)
+			return
+		}
+		// Unrelated output, e.g. a log line, is skipped.
+		writeRCON(conn, 99, rconResponseValue, "players : 99 humans")
+		writeRCON(conn, id, rconResponseValue, output)
+	})
+}
+
+func TestQueryRCON(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		output   string
+		want     int
+		wantErr  bool
+	}{
+		{
+			name:     "source status",
+			password: rconPassword,
+			output:   "hostname: FlowLens\nversion : 1.38\nplayers : 5 humans, 0 bots (24/0 max) (not hibernating)\n",
+			want:     5,
+		},
+		{
+			name:     "login rejected",
+			password: "wrong",
+			wantErr:  true,
+		},
+		{
+			name:     "no player count",
+			password: rconPassword,
+			output:   "Unknown command",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveRCON(t, tt.output)
+			got, err := QueryRCON(testContext(t), addr, tt.password, "status")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("players = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRCONInvalidSize(t *testing.T) {
+	addr := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x00})
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, _, _, err := readRCON(conn); err == nil {
+		t.Error("packet shorter than its header accepted")
+	}
+}
+
+func TestParsePlayerCount(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{output: "players : 5 humans, 0 bots (24/0 max)", want: 5},
+		{output: "hostname: Rust\nplayers : 37 (150 max) (0 queued) (0 joining)", want: 37},
+		{output: "PLAYERS: 3", want: 3},
+		{output: "There are 7 of a max of 20 players online: alice, bob", want: 7},
+		{output: "There are 0 of a max of 20 players online: ", want: 0},
+		{output: "Unknown command. Type \"/help\" for help.", wantErr: true},
+		{output: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePlayerCount(tt.output)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePlayerCount(%q) err = %v, want error %v", tt.output, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePlayerCount(%q) = %d, want %d", tt.output, got, tt.want)
+		}
+	}
+}
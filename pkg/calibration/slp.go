@@ -0,0 +1,90 @@
+package calibration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// maxSLPResponse bounds the status JSON, which carries the server icon.
+const maxSLPResponse = 1 << 20
+
+// QuerySLP asks a Minecraft Java server at addr for its player count with
+// the Server List Ping used by the client's server list (1.7 and later).
+func QuerySLP(ctx context.Context, addr string) (int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port in %s: %w", addr, err)
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	// Handshake with next state 1 (status), then an empty status request.
+	var handshake bytes.Buffer
+	handshake.WriteByte(0x00)
+	handshake.Write(binary.AppendUvarint(nil, uint64(uint32(0xffffffff)))) // protocol version -1
+	handshake.Write(binary.AppendUvarint(nil, uint64(len(host))))
+	handshake.WriteString(host)
+	handshake.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
+	handshake.WriteByte(0x01)
+
+	var request []byte
+	request = binary.AppendUvarint(request, uint64(handshake.Len()))
+	request = append(request, handshake.Bytes()...)
+	request = append(request, 0x01, 0x00)
+	if _, err := conn.Write(request); err != nil {
+		return 0, fmt.Errorf("failed to send status request: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read status response: %w", err)
+	}
+	if length > maxSLPResponse {
+		return 0, fmt.Errorf("status response of %d bytes is too large", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, fmt.Errorf("failed to read status response: %w", err)
+	}
+
+	pr := bytes.NewReader(packet)
+	if id, err := binary.ReadUvarint(pr); err != nil || id != 0x00 {
+		return 0, errors.New("malformed status response")
+	}
+	size, err := binary.ReadUvarint(pr)
+	if err != nil || size > uint64(pr.Len()) {
+		return 0, errors.New("malformed status response")
+	}
+	body := make([]byte, size)
+	pr.Read(body)
+
+	var status struct {
+		Players *struct {
+			Online int `json:"online"`
+		} `json:"players"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return 0, fmt.Errorf("failed to decode status response: %w", err)
+	}
+	if status.Players == nil {
+		return 0, errors.New("status response has no player count")
+	}
+	return status.Players.Online, nil
+}
@@ -0,0 +1,120 @@
+package calibration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readSLPPacket reads one VarInt length prefixed packet.
+func readSLPPacket(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	packet := make([]byte, length)
+	_, err = io.ReadFull(r, packet)
+	return packet, err
+}
+
+func slpStatus(status string) []byte {
+	body := binary.AppendUvarint([]byte{0x00}, uint64(len(status)))
+	body = append(body, status...)
+	return append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+}
+
+// serveSLP checks the handshake and status request and sends response.
+func serveSLP(t *testing.T, response []byte) string {
+	t.Helper()
+	return serveTCP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+
+		handshake, err := readSLPPacket(r)
+		if err != nil {
+			t.Errorf("failed to read handshake: %v", err)
+			return
+		}
+		host, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+		p, _ := strconv.Atoi(port)
+		want := []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0x0f, byte(len(host))}
+		want = append(want, host...)
+		want = binary.BigEndian.AppendUint16(want, uint16(p))
+		want = append(want, 0x01)
+		if !bytes.Equal(handshake, want) {
+			t.Errorf("handshake = % x, want % x", handshake, want)
+		}
+
+		request, err := readSLPPacket(r)
+		if err != nil || !bytes.Equal(request, []byte{0x00}) {
+			t.Errorf("status request = % x, %v, want 00", request, err)
+		}
+
+		conn.Write(response)
+	})
+}
+
+func TestQuerySLP(t *testing.T) {
+	// A server icon pushes the response past two VarInt bytes of length.
+	icon := strings.Repeat("A", 20000)
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     int
+		wantErr  bool
+	}{
+		{
+			name:     "short status",
+			response: slpStatus(`{"players":{"max":20,"online":5}}`),
+			want:     5,
+		},
+		{
+			name:     "multi-byte length",
+			response: slpStatus(`{"version":{"name":"1.21","protocol":767},"players":{"max":100,"online":42},"favicon":"data:image/png;base64,` + icon + `"}`),
+			want:     42,
+		},
+		{
+			name:     "no players",
+			response: slpStatus(`{"version":{"name":"1.21"}}`),
+			wantErr:  true,
+		},
+		{
+			name:     "invalid JSON",
+			response: slpStatus(`{"players":`),
+			wantErr:  true,
+		},
+		{
+			name:     "wrong packet ID",
+			response: []byte{0x03, 0x01, 0x01, '{'},
+			wantErr:  true,
+		},
+		{
+			name:     "string longer than packet",
+			response: []byte{0x03, 0x00, 0x10, '{', '}'},
+			wantErr:  true,
+		},
+		{
+			name:     "oversized response",
+			response: binary.AppendUvarint(nil, maxSLPResponse+1),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveSLP(t, tt.response)
+			got, err := QuerySLP(testContext(t), addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("players = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
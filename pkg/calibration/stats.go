@@ -0,0 +1,153 @@
+package calibration
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+)
+
+const (
+	// maxSuggestionSamples bounds the samples suggestions are based on, so
+	// they follow changes in how a game is played.
+	maxSuggestionSamples = 100
+	// minSuggestionSamples is how many samples a suggestion needs.
+	minSuggestionSamples = 10
+)
+
+// ErrorStats compares estimates with queried player counts since FlowLens
+// started. Errors are estimated minus actual, so a positive MeanError means
+// FlowLens overcounts.
+type ErrorStats struct {
+	Samples       int
+	QueryErrors   int
+	MeanError     float64
+	MeanAbsError  float64
+	RMSE          float64
+	LastActual    int
+	LastEstimated int
+	LastSample    time.Time
+
+	sumErr, sumAbs, sumSq float64
+}
+
+func (s *ErrorStats) add(estimated, actual int, at time.Time) {
+	diff := float64(estimated - actual)
+	s.Samples++
+	s.sumErr += diff
+	s.sumAbs += math.Abs(diff)
+	s.sumSq += diff * diff
+
+	n := float64(s.Samples)
+	s.MeanError = s.sumErr / n
+	s.MeanAbsError = s.sumAbs / n
+	s.RMSE = math.Sqrt(s.sumSq / n)
+	s.LastActual = actual
+	s.LastEstimated = estimated
+	s.LastSample = at
+}
+
+// Suggestion holds the median of the per-sample cutoffs: the values that
+// would have let exactly the queried number of clients through.
+type Suggestion struct {
+	MinPackets    uint64
+	MinBytes      uint64
+	MinPacketRate float64
+}
+
+type gameState struct {
+	errors  ErrorStats
+	current estimator.Params
+	samples []cutoffs
+}
+
+type cutoffs struct {
+	packets float64
+	bytes   float64
+	rate    float64
+}
+
+// observe ranks the clients of one server by each measure and records where
+// the cut between the queried number of players and the rest falls.
+func (g *gameState) observe(clients []estimator.ClientActivity, params estimator.Params, players int) {
+	g.current = params
+	if len(clients) == 0 {
+		return
+	}
+
+	packets := make([]float64, len(clients))
+	bytes := make([]float64, len(clients))
+	rates := make([]float64, len(clients))
+	for i, c := range clients {
+		packets[i] = float64(c.Packets)
+		bytes[i] = float64(c.Bytes)
+		rates[i] = sustainedRate(c, params.RateIntervals)
+	}
+
+	g.samples = append(g.samples, cutoffs{
+		packets: cutoff(packets, players),
+		bytes:   cutoff(bytes, players),
+		rate:    cutoff(rates, players),
+	})
+	if len(g.samples) > maxSuggestionSamples {
+		g.samples = g.samples[len(g.samples)-maxSuggestionSamples:]
+	}
+}
+
+func (g *gameState) suggest() *Suggestion {
+	if len(g.samples) < minSuggestionSamples {
+		return nil
+	}
+
+	var packets, bytes, rates []float64
+	for _, s := range g.samples {
+		packets = append(packets, s.packets)
+		bytes = append(bytes, s.bytes)
+		rates = append(rates, s.rate)
+	}
+	return &Suggestion{
+		MinPackets:    uint64(math.Round(median(packets))),
+		MinBytes:      uint64(math.Round(median(bytes))),
+		MinPacketRate: math.Round(median(rates)*10) / 10,
+	}
+}
+
+// sustainedRate is the lowest packet rate of a client over its last n
+// intervals, what the rate strategy compares with min_packet_rate.
+func sustainedRate(c estimator.ClientActivity, n int) float64 {
+	intervals := c.Intervals[:min(max(n, 1), len(c.Intervals))]
+	if len(intervals) == 0 {
+		return 0
+	}
+	rate := math.Inf(1)
+	for _, iv := range intervals {
+		rate = min(rate, float64(iv.Packets)/iv.Seconds)
+	}
+	return rate
+}
+
+// cutoff returns the threshold that lets the top players values through:
+// halfway between the last player and the first non-player, the lowest value
+// when every client is a player, or just above the highest when none is.
+func cutoff(values []float64, players int) float64 {
+	slices.Sort(values)
+	slices.Reverse(values)
+	switch {
+	case players <= 0:
+		return values[0] + 1
+	case players >= len(values):
+		return values[len(values)-1]
+	default:
+		return (values[players-1] + values[players]) / 2
+	}
+}
+
+func median(values []float64) float64 {
+	slices.Sort(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
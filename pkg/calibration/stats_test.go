@@ -0,0 +1,45 @@
+package calibration
+
+import "testing"
+
+func TestCutoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []float64
+		players int
+		want    float64
+	}{
+		{name: "between player and non-player", values: []float64{5, 40, 10, 60}, players: 2, want: 25},
+		{name: "every client a player", values: []float64{5, 40, 10}, players: 3, want: 5},
+		{name: "more players than clients", values: []float64{5, 40}, players: 4, want: 5},
+		{name: "no players", values: []float64{5, 40, 10}, players: 0, want: 41},
+		{name: "single player", values: []float64{30, 2}, players: 1, want: 16},
+		{name: "ties", values: []float64{10, 10, 10}, players: 1, want: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cutoff(tt.values, tt.players); got != tt.want {
+				t.Errorf("cutoff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{values: []float64{7}, want: 7},
+		{values: []float64{9, 1, 5}, want: 5},
+		{values: []float64{4, 1, 3, 2}, want: 2.5},
+		{values: []float64{2, 2, 8, 100}, want: 5},
+	}
+
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package clock
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// syncSamples is the number of clock readings taken per Sync. The reading
+// with the shortest wall-clock bracket has the smallest error.
+const syncSamples = 3
+
+// Source reads the clocks to correlate. Tests can inject their own.
+type Source interface {
+	Now() time.Time
+	Monotonic() (time.Duration, error)
+}
+
+type systemSource struct{}
+
+func (systemSource) Now() time.Time {
+	return time.Now()
+}
+
+// Monotonic reads CLOCK_MONOTONIC, the clock behind bpf_ktime_get_ns().
+func (systemSource) Monotonic() (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// SystemSource returns the Source backed by the host clocks.
+func SystemSource() Source {
+	return systemSource{}
+}
+
+// Correlator converts kernel monotonic timestamps (bpf_ktime_get_ns()) to
+// wall-clock time and back. CLOCK_MONOTONIC stops during suspend and is not
+// stepped by NTP, so the offset to wall time is re-sampled on every Sync
+// rather than derived once from the boot time.
+type Correlator struct {
+	source Source
+	wall   time.Time
+	mono   time.Duration
+}
+
+func New() *Correlator {
+	return NewWithSource(SystemSource())
+}
+
+func NewWithSource(source Source) *Correlator {
+	return &Correlator{source: source}
+}
+
+// Sync samples both clocks. Each sample reads wall, monotonic, wall and pairs
+// the monotonic reading with the midpoint of the two wall readings.
+func (c *Correlator) Sync() error {
+	var best time.Duration = -1
+
+	for i := 0; i < syncSamples; i++ {
+		before := c.source.Now()
+		mono, err := c.source.Monotonic()
+		if err != nil {
+			return err
+		}
+		after := c.source.Now()
+
+		spread := after.Sub(before)
+		if best < 0 || spread < best {
+			best = spread
+			c.wall = before.Add(spread / 2)
+			c.mono = mono
+		}
+	}
+
+	return nil
+}
+
+// Now returns the wall-clock time of the last Sync.
+func (c *Correlator) Now() time.Time {
+	return c.wall
+}
+
+// Monotonic returns the kernel monotonic time of the last Sync in
+// nanoseconds, comparable to bpf_ktime_get_ns().
+func (c *Correlator) Monotonic() uint64 {
+	return uint64(c.mono)
+}
+
+// ToWall converts a bpf_ktime_get_ns() timestamp to wall-clock time.
+func (c *Correlator) ToWall(ktime uint64) time.Time {
+	return c.wall.Add(time.Duration(int64(ktime) - int64(c.mono)))
+}
+
+// FromWall converts a wall-clock time to a bpf_ktime_get_ns() timestamp.
+// Times before boot clamp to zero.
+func (c *Correlator) FromWall(t time.Time) uint64 {
+	ns := int64(c.mono) + int64(t.Sub(c.wall))
+	if ns < 0 {
+		return 0
+	}
+	return uint64(ns)
+}
@@ -0,0 +1,129 @@
+package clock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeSource replays scripted readings: each Sync sample takes two from
+// walls and one from monos.
+type fakeSource struct {
+	walls []time.Time
+	monos []time.Duration
+	err   error
+}
+
+func (f *fakeSource) Now() time.Time {
+	t := f.walls[0]
+	f.walls = f.walls[1:]
+	return t
+}
+
+func (f *fakeSource) Monotonic() (time.Duration, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	m := f.monos[0]
+	f.monos = f.monos[1:]
+	return m, nil
+}
+
+var base = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) time.Time {
+	return base.Add(d)
+}
+
+func TestSyncPicksTightestSample(t *testing.T) {
+	tests := []struct {
+		name     string
+		walls    []time.Time
+		monos    []time.Duration
+		wantWall time.Time
+		wantMono uint64
+	}{
+		{
+			name:     "first sample tightest",
+			walls:    []time.Time{at(0), at(2 * time.Millisecond), at(10 * time.Millisecond), at(20 * time.Millisecond), at(30 * time.Millisecond), at(40 * time.Millisecond)},
+			monos:    []time.Duration{100 * time.Second, 101 * time.Second, 102 * time.Second},
+			wantWall: at(time.Millisecond),
+			wantMono: uint64(100 * time.Second),
+		},
+		{
+			name:     "last sample tightest",
+			walls:    []time.Time{at(0), at(10 * time.Millisecond), at(20 * time.Millisecond), at(30 * time.Millisecond), at(40 * time.Millisecond), at(42 * time.Millisecond)},
+			monos:    []time.Duration{100 * time.Second, 101 * time.Second, 102 * time.Second},
+			wantWall: at(41 * time.Millisecond),
+			wantMono: uint64(102 * time.Second),
+		},
+		{
+			name:     "tie keeps earlier sample",
+			walls:    []time.Time{at(0), at(4 * time.Millisecond), at(10 * time.Millisecond), at(14 * time.Millisecond), at(20 * time.Millisecond), at(30 * time.Millisecond)},
+			monos:    []time.Duration{5 * time.Second, 6 * time.Second, 7 * time.Second},
+			wantWall: at(2 * time.Millisecond),
+			wantMono: uint64(5 * time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewWithSource(&fakeSource{walls: tt.walls, monos: tt.monos})
+			if err := c.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+			if !c.Now().Equal(tt.wantWall) {
+				t.Errorf("Now() = %v, want %v", c.Now(), tt.wantWall)
+			}
+			if c.Monotonic() != tt.wantMono {
+				t.Errorf("Monotonic() = %d, want %d", c.Monotonic(), tt.wantMono)
+			}
+		})
+	}
+}
+
+func TestSyncError(t *testing.T) {
+	want := errors.New("clock_gettime failed")
+	c := NewWithSource(&fakeSource{walls: []time.Time{at(0)}, err: want})
+	if err := c.Sync(); !errors.Is(err, want) {
+		t.Fatalf("Sync() = %v, want %v", err, want)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	// Wall time base+1ms pairs with monotonic 100s.
+	c := NewWithSource(&fakeSource{
+		walls: []time.Time{at(0), at(2 * time.Millisecond), at(0), at(2 * time.Millisecond), at(0), at(2 * time.Millisecond)},
+		monos: []time.Duration{100 * time.Second, 100 * time.Second, 100 * time.Second},
+	})
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	syncWall := at(time.Millisecond)
+
+	tests := []struct {
+		name  string
+		ktime uint64
+		wall  time.Time
+	}{
+		{"at sync", uint64(100 * time.Second), syncWall},
+		{"before sync", uint64(40 * time.Second), syncWall.Add(-60 * time.Second)},
+		{"after sync", uint64(130*time.Second + 5), syncWall.Add(30*time.Second + 5)},
+		{"boot", 0, syncWall.Add(-100 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.ToWall(tt.ktime); !got.Equal(tt.wall) {
+				t.Errorf("ToWall(%d) = %v, want %v", tt.ktime, got, tt.wall)
+			}
+			if got := c.FromWall(tt.wall); got != tt.ktime {
+				t.Errorf("FromWall(%v) = %d, want %d", tt.wall, got, tt.ktime)
+			}
+		})
+	}
+
+	if got := c.FromWall(syncWall.Add(-time.Hour)); got != 0 {
+		t.Errorf("FromWall before boot = %d, want 0", got)
+	}
+}
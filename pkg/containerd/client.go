@@ -0,0 +1,303 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+const (
+	DefaultAddress   = "/run/containerd/containerd.sock"
+	DefaultNamespace = "default"
+
+	// nerdctl keeps a container's name and its CNI port mappings in labels.
+	// Other tools put the port mappings in an OCI annotation of the same key.
+	nameLabel         = "nerdctl/name"
+	DefaultPortsLabel = "nerdctl/ports"
+)
+
+type Client struct {
+	cli            *client.Client
+	namespace      string
+	labels         map[string]string
+	idSource       string
+	portEnvVar     string
+	portSpecSource string
+	portsLabel     string
+	labelSources   map[string]string
+	profileSource  string
+}
+
+// portMappings is the CNI runtime config carrying the portmap capability,
+// accepted as an alternative to a bare list of mappings.
+type portMappings struct {
+	PortMappings []portMapping `json:"portMappings"`
+}
+
+// portMapping is one entry of a CNI portmap capability, as stored by nerdctl.
+// JSON field matching is case-insensitive, so both nerdctl's "HostPort" and
+// the CNI "hostPort" spelling decode.
+type portMapping struct {
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP"`
+}
+
+// NewClient connects to containerd at address and discovers containers in
+// namespace. Server IDs and ports are resolved with the same sources as the
+// Docker client; published ports come from the CNI mappings in the portsLabel
+// label or OCI annotation.
+func NewClient(address, namespace, portsLabel string, labels map[string]string, idSource, portEnvVar, portSpecSource string) (*Client, error) {
+	cli, err := client.New(address, client.WithDefaultNamespace(namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create containerd client: %w", err)
+	}
+
+	return &Client{
+		cli:            cli,
+		namespace:      namespace,
+		labels:         labels,
+		idSource:       idSource,
+		portEnvVar:     portEnvVar,
+		portSpecSource: portSpecSource,
+		portsLabel:     portsLabel,
+	}, nil
+}
+
+// SetLabelSources sets the labels reported for each server, keyed by label
+// name. A source is "name", "image", "label:KEY" or "env:KEY".
+func (c *Client) SetLabelSources(sources map[string]string) {
+	c.labelSources = sources
+}
+
+// SetProfileSource sets where a server's threshold profile name is read
+// from, "label:KEY" or "env:KEY".
+func (c *Client) SetProfileSource(source string) {
+	c.profileSource = source
+}
+
+func (c *Client) Close() error {
+	return c.cli.Close()
+}
+
+func (c *Client) DiscoverGameServers(ctx context.Context) ([]discovery.ServerMetadata, error) {
+	ctx = namespaces.WithNamespace(ctx, c.namespace)
+
+	containers, err := c.cli.Containers(ctx, c.labelFilter())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	servers := make([]discovery.ServerMetadata, 0, len(containers))
+	for _, ctr := range containers {
+		srv, ok, err := c.inspectServer(ctx, ctr)
+		if err != nil {
+			slog.Debug("Error inspecting container", "id", ctr.ID(), "error", err)
+			continue
+		}
+		if ok {
+			servers = append(servers, srv)
+		}
+	}
+
+	return servers, nil
+}
+
+// InspectContainer returns the game server running in the container with id.
+// ok is false when the container is gone, not running, not matched by the
+// label filter or publishes no ports.
+func (c *Client) InspectContainer(ctx context.Context, id string) (discovery.ServerMetadata, bool, error) {
+	ctx = namespaces.WithNamespace(ctx, c.namespace)
+
+	ctr, err := c.cli.LoadContainer(ctx, id)
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return discovery.ServerMetadata{}, false, nil
+	}
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to load container: %w", err)
+	}
+
+	srv, ok, err := c.inspectServer(ctx, ctr)
+	if errors.Is(err, errdefs.ErrNotFound) {
+		// Deleted while being inspected.
+		return discovery.ServerMetadata{}, false, nil
+	}
+	return srv, ok, err
+}
+
+// labelFilter builds a containerd filter matching every configured label. An
+// empty filter matches all containers.
+func (c *Client) labelFilter() string {
+	keys := make([]string, 0, len(c.labels))
+	for key := range c.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var filter string
+	for i, key := range keys {
+		if i > 0 {
+			filter += ","
+		}
+		filter += fmt.Sprintf("labels.%s==%s", strconv.Quote(key), strconv.Quote(c.labels[key]))
+	}
+	return filter
+}
+
+func (c *Client) inspectServer(ctx context.Context, ctr client.Container) (discovery.ServerMetadata, bool, error) {
+	task, err := ctr.Task(ctx, nil)
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return discovery.ServerMetadata{}, false, nil
+	}
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to load task: %w", err)
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to read task status: %w", err)
+	}
+	if status.Status != client.Running {
+		return discovery.ServerMetadata{}, false, nil
+	}
+
+	info, err := ctr.Info(ctx)
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to load container info: %w", err)
+	}
+	for key, val := range c.labels {
+		if info.Labels[key] != val {
+			return discovery.ServerMetadata{}, false, nil
+		}
+	}
+	spec, err := ctr.Spec(ctx)
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to load container spec: %w", err)
+	}
+
+	var env []string
+	if spec.Process != nil {
+		env = spec.Process.Env
+	}
+
+	name := info.Labels[nameLabel]
+	if name == "" {
+		name = info.ID
+	}
+
+	serverID := c.extractID(info.ID, name, spec.Hostname, info.Labels, env)
+	if serverID == "" {
+		return discovery.ServerMetadata{}, false, nil
+	}
+
+	var portSpec []discovery.PortRange
+	if raw, ok := discovery.LookupSource(c.portSpecSource, info.Labels, env); ok {
+		parsed, err := discovery.ParsePortSpec(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid port spec", "container", name, "spec", raw, "error", err)
+		} else {
+			portSpec = parsed
+		}
+	}
+
+	gamePort, ports := discovery.ContainerPorts(portSpec, discovery.EnvPort(env, c.portEnvVar), c.publishedPorts(name, info.Labels, spec.Annotations))
+	if len(ports) == 0 {
+		return discovery.ServerMetadata{}, false, nil
+	}
+
+	labels := discovery.ResolveLabels(c.labelSources, func(source string) (string, bool) {
+		switch source {
+		case "name":
+			return name, true
+		case "image":
+			return info.Image, true
+		}
+		return discovery.LookupSource(source, info.Labels, env)
+	})
+
+	profile, _ := discovery.LookupSource(c.profileSource, info.Labels, env)
+
+	return discovery.ServerMetadata{
+		ServerID:      serverID,
+		GamePort:      gamePort,
+		Ports:         ports,
+		ContainerID:   info.ID,
+		ContainerName: name,
+		Image:         info.Image,
+		Profile:       profile,
+		Labels:        labels,
+		LastUpdated:   time.Now(),
+	}, true, nil
+}
+
+func (c *Client) extractID(id, name, hostname string, labels map[string]string, env []string) string {
+	switch c.idSource {
+	case "hostname":
+		return hostname
+	case "id":
+		return id
+	case "name":
+		return name
+	default:
+		if val, ok := discovery.LookupSource(c.idSource, labels, env); ok {
+			return val
+		}
+		return hostname
+	}
+}
+
+// publishedPorts returns the host ports from the CNI port mappings, lowest
+// first. The label wins over an OCI annotation of the same key.
+func (c *Client) publishedPorts(name string, labels, annotations map[string]string) []int {
+	raw := labels[c.portsLabel]
+	if raw == "" {
+		raw = annotations[c.portsLabel]
+	}
+	if raw == "" {
+		return nil
+	}
+
+	mappings, err := parsePortMappings(raw)
+	if err != nil {
+		slog.Warn("Ignoring invalid port mappings", "container", name, "key", c.portsLabel, "error", err)
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var ports []int
+	for _, m := range mappings {
+		if m.HostPort <= 0 || m.HostPort > 65535 || seen[m.HostPort] {
+			continue
+		}
+		seen[m.HostPort] = true
+		ports = append(ports, m.HostPort)
+	}
+
+	sort.Ints(ports)
+	return ports
+}
+
+// parsePortMappings decodes a list of CNI port mappings, either bare as
+// nerdctl stores them or as {"portMappings": [...]} runtime config.
+func parsePortMappings(raw string) ([]portMapping, error) {
+	var mappings []portMapping
+	if err := json.Unmarshal([]byte(raw), &mappings); err == nil {
+		return mappings, nil
+	}
+
+	var config portMappings
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, err
+	}
+	return config.PortMappings, nil
+}
@@ -0,0 +1,197 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/typeurl/v2"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// topics are the containerd events that can change the server set.
+var topics = []string{
+	"/tasks/start",
+	"/tasks/exit",
+	"/tasks/delete",
+	"/containers/update",
+	"/containers/delete",
+}
+
+// Watcher keeps the set of game servers up to date from containerd events.
+// An event re-inspects only the container it is about; resyncInterval
+// triggers a full resync.
+type Watcher struct {
+	client         *Client
+	resyncInterval time.Duration
+
+	// servers holds the current server set by container ID.
+	servers map[string]discovery.ServerMetadata
+}
+
+func NewWatcher(client *Client, resyncInterval time.Duration) *Watcher {
+	return &Watcher{
+		client:         client,
+		resyncInterval: resyncInterval,
+	}
+}
+
+func (w *Watcher) Name() string {
+	return "containerd"
+}
+
+// Run sends the full server set on updates after every change. It
+// resubscribes with exponential backoff when containerd goes away and only
+// returns once ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	backoff := minBackoff
+	for {
+		connected, err := w.watch(ctx, updates)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minBackoff
+		}
+
+		slog.Warn("Container event stream interrupted, reconnecting", "runtime", "containerd", "error", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context, updates chan<- []discovery.ServerMetadata) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	filters := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		filters = append(filters, fmt.Sprintf("namespace==%s,topic==%q", w.client.namespace, topic))
+	}
+
+	// Subscribe before resyncing so nothing that happens in between is lost.
+	msgs, errs := w.client.cli.Subscribe(ctx, filters...)
+
+	if err := w.resync(ctx, updates); err != nil {
+		return false, err
+	}
+
+	ticker := time.NewTicker(w.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-errs:
+			return true, err
+		case env := <-msgs:
+			id := eventContainerID(env)
+			if id == "" {
+				if err := w.resync(ctx, updates); err != nil {
+					return true, err
+				}
+				continue
+			}
+			w.refresh(ctx, id, updates)
+		case <-ticker.C:
+			if err := w.resync(ctx, updates); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (w *Watcher) resync(ctx context.Context, updates chan<- []discovery.ServerMetadata) error {
+	servers, err := w.client.DiscoverGameServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.servers = make(map[string]discovery.ServerMetadata, len(servers))
+	for _, srv := range servers {
+		w.servers[srv.ContainerID] = srv
+	}
+
+	w.send(ctx, updates)
+	return nil
+}
+
+// refresh re-inspects one container and sends the server set if it changed
+// it. On errors the previous state is kept until the next resync.
+func (w *Watcher) refresh(ctx context.Context, id string, updates chan<- []discovery.ServerMetadata) {
+	srv, ok, err := w.client.InspectContainer(ctx, id)
+	if err != nil {
+		slog.Debug("Error inspecting container", "id", id, "error", err)
+		return
+	}
+
+	_, known := w.servers[id]
+	if !ok && !known {
+		return
+	}
+	if ok {
+		w.servers[id] = srv
+	} else {
+		delete(w.servers, id)
+	}
+
+	w.send(ctx, updates)
+}
+
+func (w *Watcher) send(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	servers := make([]discovery.ServerMetadata, 0, len(w.servers))
+	for _, srv := range w.servers {
+		servers = append(servers, srv)
+	}
+	// A stable order keeps the owner of a port claimed twice from flipping.
+	slices.SortFunc(servers, func(a, b discovery.ServerMetadata) int {
+		return strings.Compare(a.ServerID, b.ServerID)
+	})
+
+	select {
+	case updates <- servers:
+	case <-ctx.Done():
+	}
+}
+
+// eventContainerID returns the container an event is about, or "" when it
+// can't be decoded.
+func eventContainerID(env *events.Envelope) string {
+	if env == nil || env.Event == nil {
+		return ""
+	}
+	ev, err := typeurl.UnmarshalAny(env.Event)
+	if err != nil {
+		return ""
+	}
+
+	switch ev := ev.(type) {
+	case *apievents.TaskStart:
+		return ev.ContainerID
+	case *apievents.TaskExit:
+		return ev.ContainerID
+	case *apievents.TaskDelete:
+		return ev.ContainerID
+	case *apievents.ContainerUpdate:
+		return ev.ID
+	case *apievents.ContainerDelete:
+		return ev.ID
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Discoverer is a source of game servers. Run sends the full set of servers
+// the source knows about on updates every time it changes, and returns once
+// ctx is cancelled.
+type Discoverer interface {
+	Name() string
+	Run(ctx context.Context, updates chan<- []ServerMetadata)
+}
+
+// Enricher adds metadata to discovered servers, e.g. from an external API.
+// Enrich must not block; Run keeps whatever Enrich needs up to date in the
+// background, signals changed when it did, and returns once ctx is cancelled.
+type Enricher interface {
+	Enrich(servers []ServerMetadata) []ServerMetadata
+	Run(ctx context.Context, changed chan<- struct{})
+}
+
+// startupTimeout bounds how long the Manager holds back its first update for
+// a discoverer that has not reported yet.
+const startupTimeout = 30 * time.Second
+
+// Manager runs several discoverers and merges their results. A port claimed
+// by two servers stays with the discoverer listed first, or within one
+// discoverer with the server listed first.
+type Manager struct {
+	discoverers []Discoverer
+	enrichers   []Enricher
+	conflicts   map[string]bool
+	complete    atomic.Bool
+}
+
+func NewManager(discoverers ...Discoverer) *Manager {
+	return &Manager{
+		discoverers: discoverers,
+		conflicts:   make(map[string]bool),
+	}
+}
+
+// AddEnricher applies e to the servers of every discoverer before they are
+// merged, so ports it adds take part in conflict detection. The merged set is
+// sent again whenever e reports a change. It must be called before Run.
+func (m *Manager) AddEnricher(e Enricher) {
+	m.enrichers = append(m.enrichers, e)
+}
+
+// Complete reports whether every discoverer has reported at least once, so
+// the merged set no longer lacks the servers of a slow source.
+func (m *Manager) Complete() bool {
+	return m.complete.Load()
+}
+
+type providerUpdate struct {
+	index   int
+	servers []ServerMetadata
+}
+
+// Run sends the merged server set on updates every time a discoverer reports.
+// The first update waits until every discoverer has reported once, or
+// startupTimeout has passed, so a slow source does not briefly look empty.
+func (m *Manager) Run(ctx context.Context, updates chan<- []ServerMetadata) {
+	provided := make(chan providerUpdate)
+	for i, d := range m.discoverers {
+		ch := make(chan []ServerMetadata, 1)
+		go d.Run(ctx, ch)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case servers := <-ch:
+					select {
+					case provided <- providerUpdate{index: i, servers: servers}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	enriched := make(chan struct{}, 1)
+	for _, e := range m.enrichers {
+		go e.Run(ctx, enriched)
+	}
+
+	latest := make([][]ServerMetadata, len(m.discoverers))
+	reported := make([]bool, len(m.discoverers))
+	pending := len(m.discoverers)
+	started := false
+
+	startup := time.NewTimer(startupTimeout)
+	defer startup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-startup.C:
+			if started {
+				continue
+			}
+			for i, ok := range reported {
+				if !ok {
+					slog.Warn("Discovery source has not reported yet, continuing without it", "source", m.discoverers[i].Name())
+				}
+			}
+			started = true
+
+		case <-enriched:
+			if !started {
+				continue
+			}
+
+		case u := <-provided:
+			latest[u.index] = u.servers
+			if !reported[u.index] {
+				reported[u.index] = true
+				pending--
+			}
+			if pending == 0 {
+				m.complete.Store(true)
+				started = true
+			}
+			if !started {
+				continue
+			}
+		}
+
+		select {
+		case updates <- m.merge(m.enrich(latest)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enrich applies every enricher to each server set.
+func (m *Manager) enrich(sets [][]ServerMetadata) [][]ServerMetadata {
+	if len(m.enrichers) == 0 {
+		return sets
+	}
+
+	enriched := make([][]ServerMetadata, len(sets))
+	for i, set := range sets {
+		for _, e := range m.enrichers {
+			set = e.Enrich(set)
+		}
+		enriched[i] = set
+	}
+	return enriched
+}
+
+// merge combines the server sets of all discoverers. Servers with the same
+// ID are combined; ports already claimed by another server are dropped and
+// reported once per conflict.
+func (m *Manager) merge(sets [][]ServerMetadata) []ServerMetadata {
+	owners := make(map[int]string)
+	byID := make(map[string]int)
+	var merged []ServerMetadata
+	conflicts := make(map[string]bool)
+
+	for i, set := range sets {
+		for _, srv := range set {
+			var ports []PortRange
+			for _, r := range srv.Ports {
+				ports = append(ports, m.claim(owners, conflicts, r, srv.ServerID, m.discoverers[i].Name())...)
+			}
+			if len(ports) == 0 {
+				continue
+			}
+
+			if idx, ok := byID[srv.ServerID]; ok {
+				merged[idx].Ports = append(merged[idx].Ports, ports...)
+				continue
+			}
+
+			srv.Ports = ports
+			if srv.GamePort != 0 && owners[srv.GamePort] != srv.ServerID {
+				srv.GamePort = 0
+			}
+			byID[srv.ServerID] = len(merged)
+			merged = append(merged, srv)
+		}
+	}
+
+	m.conflicts = conflicts
+	return merged
+}
+
+// claim assigns the ports of r to serverID and returns the sub-ranges it
+// won. Ports owned by another server are left out.
+func (m *Manager) claim(owners map[int]string, conflicts map[string]bool, r PortRange, serverID, source string) []PortRange {
+	var won []PortRange
+	var lost []int
+
+	for port := r.Start; port <= r.End; port++ {
+		if owner, taken := owners[port]; taken && owner != serverID {
+			lost = append(lost, port)
+			key := owner + "/" + serverID + "/" + r.String()
+			if !m.conflicts[key] && !conflicts[key] {
+				slog.Warn("Port claimed by more than one server, ignoring the later claim", "port", port, "server_id", owner, "conflicting_server_id", serverID, "source", source)
+			}
+			conflicts[key] = true
+			continue
+		}
+		owners[port] = serverID
+
+		if n := len(won); n > 0 && won[n-1].End == port-1 {
+			won[n-1].End = port
+		} else {
+			won = append(won, PortRange{Start: port, End: port, Role: r.Role})
+		}
+	}
+
+	return won
+}
@@ -0,0 +1,135 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+type fakeDiscoverer string
+
+func (d fakeDiscoverer) Name() string { return string(d) }
+
+func (d fakeDiscoverer) Run(ctx context.Context, updates chan<- []ServerMetadata) {}
+
+func game(start, end int) PortRange {
+	return PortRange{Start: start, End: end, Role: RoleGame}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		sets [][]ServerMetadata
+		want []ServerMetadata
+	}{
+		{
+			name: "disjoint servers",
+			sets: [][]ServerMetadata{
+				{{ServerID: "a", GamePort: 1000, Ports: []PortRange{game(1000, 1000)}}},
+				{{ServerID: "b", GamePort: 2000, Ports: []PortRange{game(2000, 2000)}}},
+			},
+			want: []ServerMetadata{
+				{ServerID: "a", GamePort: 1000, Ports: []PortRange{game(1000, 1000)}},
+				{ServerID: "b", GamePort: 2000, Ports: []PortRange{game(2000, 2000)}},
+			},
+		},
+		{
+			name: "same server from two sources",
+			sets: [][]ServerMetadata{
+				{{ServerID: "a", GamePort: 1000, Ports: []PortRange{game(1000, 1000)}}},
+				{{ServerID: "a", Ports: []PortRange{{Start: 1001, End: 1001, Role: RoleQuery}}}},
+			},
+			want: []ServerMetadata{
+				{ServerID: "a", GamePort: 1000, Ports: []PortRange{game(1000, 1000), {Start: 1001, End: 1001, Role: RoleQuery}}},
+			},
+		},
+		{
+			name: "first source keeps a contested port",
+			sets: [][]ServerMetadata{
+				{{ServerID: "a", Ports: []PortRange{game(1000, 1004)}}},
+				{{ServerID: "b", GamePort: 1002, Ports: []PortRange{game(1002, 1002), game(1004, 1006)}}},
+			},
+			want: []ServerMetadata{
+				{ServerID: "a", Ports: []PortRange{game(1000, 1004)}},
+				{ServerID: "b", Ports: []PortRange{game(1005, 1006)}},
+			},
+		},
+		{
+			name: "first server within a source keeps a contested port",
+			sets: [][]ServerMetadata{{
+				{ServerID: "a", Ports: []PortRange{game(1000, 1000)}},
+				{ServerID: "b", Ports: []PortRange{game(999, 1001)}},
+			}},
+			want: []ServerMetadata{
+				{ServerID: "a", Ports: []PortRange{game(1000, 1000)}},
+				{ServerID: "b", Ports: []PortRange{game(999, 999), game(1001, 1001)}},
+			},
+		},
+		{
+			name: "server without ports left is dropped",
+			sets: [][]ServerMetadata{
+				{{ServerID: "a", Ports: []PortRange{game(1000, 1001)}}},
+				{{ServerID: "b", GamePort: 1000, Ports: []PortRange{game(1000, 1001)}}},
+			},
+			want: []ServerMetadata{
+				{ServerID: "a", Ports: []PortRange{game(1000, 1001)}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(fakeDiscoverer("first"), fakeDiscoverer("second"))
+			got := m.merge(tt.sets)
+			if len(got) != len(tt.want) {
+				t.Fatalf("merge() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].ServerID != tt.want[i].ServerID || got[i].GamePort != tt.want[i].GamePort || !slices.Equal(got[i].Ports, tt.want[i].Ports) {
+					t.Errorf("server %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		name         string
+		owners       map[int]string
+		r            PortRange
+		want         []PortRange
+		wantConflict bool
+	}{
+		{name: "free range", owners: map[int]string{}, r: game(10, 12), want: []PortRange{game(10, 12)}},
+		{name: "own ports", owners: map[int]string{11: "a"}, r: game(10, 12), want: []PortRange{game(10, 12)}},
+		{name: "taken port splits the range", owners: map[int]string{11: "b"}, r: game(10, 12), want: []PortRange{game(10, 10), game(12, 12)}, wantConflict: true},
+		{name: "everything taken", owners: map[int]string{10: "b", 11: "b"}, r: game(10, 11), wantConflict: true},
+		{
+			name:         "role is kept",
+			owners:       map[int]string{10: "b"},
+			r:            PortRange{Start: 10, End: 11, Role: RoleVoice},
+			want:         []PortRange{{Start: 11, End: 11, Role: RoleVoice}},
+			wantConflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			conflicts := make(map[string]bool)
+			got := m.claim(tt.owners, conflicts, tt.r, "a", "test")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("claim() = %v, want %v", got, tt.want)
+			}
+			for port := tt.r.Start; port <= tt.r.End; port++ {
+				if tt.owners[port] == "" {
+					t.Errorf("port %d has no owner", port)
+				}
+			}
+			if got := len(conflicts) > 0; got != tt.wantConflict {
+				t.Errorf("conflict recorded = %v, want %v", got, tt.wantConflict)
+			}
+		})
+	}
+}
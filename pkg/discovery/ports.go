@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PortRole string
+
+const (
+	RoleGame  PortRole = "game"
+	RoleQuery PortRole = "query"
+	RoleRCON  PortRole = "rcon"
+	RoleVoice PortRole = "voice"
+)
+
+// maxPortRange caps the size of a single range so one bad declaration cannot
+// fill the monitored_ports map. It stays within the default map size.
+const maxPortRange = 1000
+
+// PortRange is an inclusive range of ports serving one role. Single ports
+// have Start == End.
+type PortRange struct {
+	Start int
+	End   int
+	Role  PortRole
+}
+
+func (r PortRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%s:%d", r.Role, r.Start)
+	}
+	return fmt.Sprintf("%s:%d-%d", r.Role, r.Start, r.End)
+}
+
+// RoleFromName derives a role from a port name such as "query" or
+// "rcon-admin". Unknown names are game ports.
+func RoleFromName(name string) PortRole {
+	prefix, _, _ := strings.Cut(strings.ToLower(name), "-")
+	switch role := PortRole(prefix); role {
+	case RoleQuery, RoleRCON, RoleVoice:
+		return role
+	default:
+		return RoleGame
+	}
+}
+
+// ParsePortSpec parses a comma-separated list of [role:]port[-end] entries,
+// e.g. "game:27015,query:27016,voice:9987,game:28000-28010". Entries without
+// a role are game ports.
+func ParsePortSpec(spec string) ([]PortRange, error) {
+	var ranges []PortRange
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role := RoleGame
+		if r, ports, ok := strings.Cut(entry, ":"); ok {
+			role = PortRole(strings.ToLower(strings.TrimSpace(r)))
+			entry = strings.TrimSpace(ports)
+		}
+		switch role {
+		case RoleGame, RoleQuery, RoleRCON, RoleVoice:
+		default:
+			return nil, fmt.Errorf("unknown port role %q", role)
+		}
+
+		startStr, endStr, isRange := strings.Cut(entry, "-")
+		start, err := parsePort(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = parsePort(endStr); err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid port range %q", entry)
+		}
+		if end-start >= maxPortRange {
+			return nil, fmt.Errorf("port range %q exceeds %d ports", entry, maxPortRange)
+		}
+
+		ranges = append(ranges, PortRange{Start: start, End: end, Role: role})
+	}
+
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
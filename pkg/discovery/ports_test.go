@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePortSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []PortRange
+		wantErr bool
+	}{
+		{name: "empty", spec: ""},
+		{name: "bare port is game", spec: "27015", want: []PortRange{{Start: 27015, End: 27015, Role: RoleGame}}},
+		{
+			name: "roles and ranges",
+			spec: "game:27015, Query:27016,voice:9987,game:28000-28010",
+			want: []PortRange{
+				{Start: 27015, End: 27015, Role: RoleGame},
+				{Start: 27016, End: 27016, Role: RoleQuery},
+				{Start: 9987, End: 9987, Role: RoleVoice},
+				{Start: 28000, End: 28010, Role: RoleGame},
+			},
+		},
+		{name: "empty entries skipped", spec: ",rcon:25575,", want: []PortRange{{Start: 25575, End: 25575, Role: RoleRCON}}},
+		{name: "largest range", spec: "1000-1999", want: []PortRange{{Start: 1000, End: 1999, Role: RoleGame}}},
+		{name: "range too large", spec: "1000-2000", wantErr: true},
+		{name: "reversed range", spec: "2000-1000", wantErr: true},
+		{name: "unknown role", spec: "web:80", wantErr: true},
+		{name: "port zero", spec: "0", wantErr: true},
+		{name: "port out of range", spec: "65536", wantErr: true},
+		{name: "not a number", spec: "game:abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePortSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParsePortSpec(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
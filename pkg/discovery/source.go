@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LookupSource resolves a "label:KEY" or "env:KEY" source against a
+// container's labels and environment.
+func LookupSource(source string, labels map[string]string, env []string) (string, bool) {
+	if labelKey, ok := strings.CutPrefix(source, "label:"); ok {
+		val, ok := labels[labelKey]
+		return val, ok
+	}
+	if envKey, ok := strings.CutPrefix(source, "env:"); ok {
+		return LookupEnv(env, envKey)
+	}
+	return "", false
+}
+
+// LookupEnv returns the value of key in a KEY=VALUE environment list.
+func LookupEnv(env []string, key string) (string, bool) {
+	for _, e := range env {
+		if val, ok := strings.CutPrefix(e, key+"="); ok {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// EnvPort reads a port number from the environment variable key. It returns 0
+// when the variable is unset or not a valid port.
+func EnvPort(env []string, key string) int {
+	if key == "" {
+		return 0
+	}
+	val, ok := LookupEnv(env, key)
+	if !ok {
+		return 0
+	}
+	port, err := strconv.Atoi(val)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0
+	}
+	return port
+}
+
+// ContainerPorts returns the primary game port and every port range of a
+// container. A port spec is authoritative; without one the port from the
+// environment, or else the lowest published port, is the only game port.
+func ContainerPorts(spec []PortRange, envPort int, published []int) (int, []PortRange) {
+	for _, r := range spec {
+		if r.Role == RoleGame {
+			return r.Start, spec
+		}
+	}
+
+	gamePort := envPort
+	if gamePort == 0 && len(published) > 0 {
+		gamePort = published[0]
+	}
+	if gamePort == 0 {
+		return 0, spec
+	}
+
+	return gamePort, append([]PortRange{{Start: gamePort, End: gamePort, Role: RoleGame}}, spec...)
+}
+
+// ResolveLabels evaluates a set of label sources, keyed by label name, with
+// lookup. Sources that don't resolve are left out.
+func ResolveLabels(sources map[string]string, lookup func(source string) (string, bool)) map[string]string {
+	if len(sources) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(sources))
+	for name, source := range sources {
+		if val, ok := lookup(source); ok && val != "" {
+			labels[name] = val
+		}
+	}
+	return labels
+}
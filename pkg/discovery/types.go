@@ -1,11 +1,15 @@
-package docker
+package discovery
 
 import "time"
 
 type ServerMetadata struct {
 	ServerID      string
 	GamePort      int
+	Ports         []PortRange
 	ContainerID   string
 	ContainerName string
+	Image         string
+	Profile       string
+	Labels        map[string]string
 	LastUpdated   time.Time
 }
@@ -3,6 +3,8 @@ package docker
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -11,38 +13,72 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
 )
 
+// DefaultPodmanSocket is the API socket of rootful Podman.
+const DefaultPodmanSocket = "unix:///run/podman/podman.sock"
+
 type Client struct {
-	cli        *client.Client
-	labels     map[string]string
-	idSource   string
-	portEnvVar string
+	cli            *client.Client
+	runtime        string
+	labels         map[string]string
+	idSource       string
+	portEnvVar     string
+	portSpecSource string
+	labelSources   map[string]string
+	profileSource  string
 }
 
-func NewClient(labels map[string]string, idSource, portEnvVar string) (*Client, error) {
+func NewClient(labels map[string]string, idSource, portEnvVar, portSpecSource string) (*Client, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
 
+	return newClient(cli, "docker", labels, idSource, portEnvVar, portSpecSource), nil
+}
+
+// NewPodmanClient connects to the Docker-compatible API of Podman at host,
+// e.g. DefaultPodmanSocket.
+func NewPodmanClient(host string, labels map[string]string, idSource, portEnvVar, portSpecSource string) (*Client, error) {
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create podman client: %w", err)
+	}
+
+	return newClient(cli, "podman", labels, idSource, portEnvVar, portSpecSource), nil
+}
+
+func newClient(cli *client.Client, runtime string, labels map[string]string, idSource, portEnvVar, portSpecSource string) *Client {
 	return &Client{
-		cli:        cli,
-		labels:     labels,
-		idSource:   idSource,
-		portEnvVar: portEnvVar,
-	}, nil
+		cli:            cli,
+		runtime:        runtime,
+		labels:         labels,
+		idSource:       idSource,
+		portEnvVar:     portEnvVar,
+		portSpecSource: portSpecSource,
+	}
+}
+
+// SetLabelSources sets the labels reported for each server, keyed by label
+// name. A source is "name", "image", "label:KEY" or "env:KEY".
+func (c *Client) SetLabelSources(sources map[string]string) {
+	c.labelSources = sources
+}
+
+// SetProfileSource sets where a server's threshold profile name is read
+// from, "label:KEY" or "env:KEY".
+func (c *Client) SetProfileSource(source string) {
+	c.profileSource = source
 }
 
 func (c *Client) Close() error {
 	return c.cli.Close()
 }
 
-func (c *Client) DiscoverGameServers(ctx context.Context) ([]ServerMetadata, error) {
-	filterArgs := filters.NewArgs()
-	for key, value := range c.labels {
-		filterArgs.Add("label", fmt.Sprintf("%s=%s", key, value))
-	}
+func (c *Client) DiscoverGameServers(ctx context.Context) ([]discovery.ServerMetadata, error) {
+	filterArgs := c.labelFilters()
 	filterArgs.Add("status", "running")
 
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
@@ -52,35 +88,75 @@ func (c *Client) DiscoverGameServers(ctx context.Context) ([]ServerMetadata, err
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
-	servers := make([]ServerMetadata, 0, len(containers))
-	now := time.Now()
+	servers := make([]discovery.ServerMetadata, 0, len(containers))
 
 	for _, ctr := range containers {
-		inspect, err := c.cli.ContainerInspect(ctx, ctr.ID)
-		if err != nil {
+		srv, ok, err := c.inspectServer(ctx, ctr.ID)
+		if err != nil || !ok {
 			continue
 		}
+		servers = append(servers, srv)
+	}
 
-		serverID := c.extractID(inspect)
-		if serverID == "" {
-			continue
-		}
+	return servers, nil
+}
 
-		gamePort := c.extractPort(inspect, ctr.Ports)
-		if gamePort == 0 {
-			continue
-		}
+// inspectServer builds the metadata for a single container. It reports false
+// when the container is not running or has no usable server ID or port.
+func (c *Client) inspectServer(ctx context.Context, id string) (discovery.ServerMetadata, bool, error) {
+	inspect, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return discovery.ServerMetadata{}, false, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
 
-		servers = append(servers, ServerMetadata{
-			ServerID:      serverID,
-			GamePort:      gamePort,
-			ContainerID:   ctr.ID,
-			ContainerName: strings.TrimPrefix(ctr.Names[0], "/"),
-			LastUpdated:   now,
-		})
+	if inspect.State == nil || !inspect.State.Running {
+		return discovery.ServerMetadata{}, false, nil
 	}
 
-	return servers, nil
+	c.resolveSharedNetwork(ctx, &inspect)
+
+	serverID := c.extractID(inspect)
+	if serverID == "" {
+		return discovery.ServerMetadata{}, false, nil
+	}
+
+	gamePort, ports := c.extractPorts(inspect)
+	if len(ports) == 0 {
+		return discovery.ServerMetadata{}, false, nil
+	}
+
+	name := strings.TrimPrefix(inspect.Name, "/")
+	labels := discovery.ResolveLabels(c.labelSources, func(source string) (string, bool) {
+		switch source {
+		case "name":
+			return name, true
+		case "image":
+			return inspect.Config.Image, true
+		}
+		return lookupSource(inspect, source)
+	})
+
+	profile, _ := lookupSource(inspect, c.profileSource)
+
+	return discovery.ServerMetadata{
+		ServerID:      serverID,
+		GamePort:      gamePort,
+		Ports:         ports,
+		ContainerID:   inspect.ID,
+		ContainerName: name,
+		Image:         inspect.Config.Image,
+		Profile:       profile,
+		Labels:        labels,
+		LastUpdated:   time.Now(),
+	}, true, nil
+}
+
+func (c *Client) labelFilters() filters.Args {
+	filterArgs := filters.NewArgs()
+	for key, value := range c.labels {
+		filterArgs.Add("label", fmt.Sprintf("%s=%s", key, value))
+	}
+	return filterArgs
 }
 
 func (c *Client) extractID(inspect types.ContainerJSON) string {
@@ -92,40 +168,70 @@ func (c *Client) extractID(inspect types.ContainerJSON) string {
 	case "name":
 		return strings.TrimPrefix(inspect.Name, "/")
 	default:
-		if strings.HasPrefix(c.idSource, "label:") {
-			labelKey := strings.TrimPrefix(c.idSource, "label:")
-			if val, ok := inspect.Config.Labels[labelKey]; ok {
-				return val
-			}
-		} else if strings.HasPrefix(c.idSource, "env:") {
-			envKey := strings.TrimPrefix(c.idSource, "env:")
-			for _, e := range inspect.Config.Env {
-				if strings.HasPrefix(e, envKey+"=") {
-					return strings.TrimPrefix(e, envKey+"=")
-				}
-			}
+		if val, ok := lookupSource(inspect, c.idSource); ok {
+			return val
 		}
 		return inspect.Config.Hostname
 	}
 }
 
-func (c *Client) extractPort(inspect types.ContainerJSON, ports []container.Port) int {
-	if c.portEnvVar != "" {
-		for _, e := range inspect.Config.Env {
-			if strings.HasPrefix(e, c.portEnvVar+"=") {
-				portStr := strings.TrimPrefix(e, c.portEnvVar+"=")
-				if port, err := strconv.Atoi(portStr); err == nil && port > 0 && port <= 65535 {
-					return port
-				}
-			}
+// resolveSharedNetwork replaces the network settings of a container that
+// joined another container's network namespace, such as a member of a Podman
+// pod, with those of the container owning it. Ports are only published there.
+func (c *Client) resolveSharedNetwork(ctx context.Context, inspect *types.ContainerJSON) {
+	if inspect.HostConfig == nil || !inspect.HostConfig.NetworkMode.IsContainer() {
+		return
+	}
+
+	owner, err := c.cli.ContainerInspect(ctx, inspect.HostConfig.NetworkMode.ConnectedContainer())
+	if err != nil {
+		slog.Debug("Error inspecting network owner", "container", strings.TrimPrefix(inspect.Name, "/"), "error", err)
+		return
+	}
+	inspect.NetworkSettings = owner.NetworkSettings
+}
+
+// extractPorts returns the primary game port and every port range of the
+// server. A port spec found through portSpecSource is authoritative; without
+// one the port from portEnvVar, or the lowest published port, is the only
+// game port.
+func (c *Client) extractPorts(inspect types.ContainerJSON) (int, []discovery.PortRange) {
+	var spec []discovery.PortRange
+	if raw, ok := lookupSource(inspect, c.portSpecSource); ok {
+		parsed, err := discovery.ParsePortSpec(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid port spec", "container", strings.TrimPrefix(inspect.Name, "/"), "spec", raw, "error", err)
+		} else {
+			spec = parsed
 		}
 	}
 
-	for _, port := range ports {
-		if port.PublicPort > 0 && port.PublicPort <= 65535 {
-			return int(port.PublicPort)
+	return discovery.ContainerPorts(spec, discovery.EnvPort(inspect.Config.Env, c.portEnvVar), publishedPorts(inspect))
+}
+
+func lookupSource(inspect types.ContainerJSON, source string) (string, bool) {
+	return discovery.LookupSource(source, inspect.Config.Labels, inspect.Config.Env)
+}
+
+// publishedPorts returns the host ports a container publishes, lowest first.
+func publishedPorts(inspect types.ContainerJSON) []int {
+	if inspect.NetworkSettings == nil {
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var ports []int
+	for _, bindings := range inspect.NetworkSettings.Ports {
+		for _, b := range bindings {
+			port, err := strconv.Atoi(b.HostPort)
+			if err != nil || port <= 0 || port > 65535 || seen[port] {
+				continue
+			}
+			seen[port] = true
+			ports = append(ports, port)
 		}
 	}
 
-	return 0
+	sort.Ints(ports)
+	return ports
 }
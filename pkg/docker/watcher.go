@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// actionDied is what some Podman versions send instead of "die" on their
+// Docker-compatible events endpoint.
+const actionDied events.Action = "died"
+
+// Watcher keeps the set of game servers up to date from the Docker events
+// stream. A full resync runs on connect and every resyncInterval to recover
+// from missed events.
+type Watcher struct {
+	client         *Client
+	resyncInterval time.Duration
+	servers        map[string]discovery.ServerMetadata
+}
+
+func NewWatcher(client *Client, resyncInterval time.Duration) *Watcher {
+	return &Watcher{
+		client:         client,
+		resyncInterval: resyncInterval,
+		servers:        make(map[string]discovery.ServerMetadata),
+	}
+}
+
+func (w *Watcher) Name() string {
+	return w.client.runtime
+}
+
+// Run sends the full server set on updates every time it changes. It
+// reconnects with exponential backoff when the daemon goes away and only
+// returns once ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	backoff := minBackoff
+	for {
+		connected, err := w.watch(ctx, updates)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minBackoff
+		}
+
+		slog.Warn("Container event stream interrupted, reconnecting", "runtime", w.client.runtime, "error", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context, updates chan<- []discovery.ServerMetadata) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	filterArgs := w.client.labelFilters()
+	filterArgs.Add("type", string(events.ContainerEventType))
+	for _, action := range []events.Action{events.ActionStart, events.ActionDie, events.ActionRename, events.ActionUpdate} {
+		filterArgs.Add("event", string(action))
+	}
+	if w.client.runtime == "podman" {
+		filterArgs.Add("event", string(actionDied))
+	}
+
+	// Subscribe before resyncing so nothing that happens in between is lost.
+	msgs, errs := w.client.cli.Events(ctx, events.ListOptions{Filters: filterArgs})
+
+	if err := w.resync(ctx, updates); err != nil {
+		return false, err
+	}
+
+	ticker := time.NewTicker(w.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-errs:
+			return true, err
+		case msg := <-msgs:
+			if w.handleEvent(ctx, msg) {
+				w.publish(ctx, updates)
+			}
+		case <-ticker.C:
+			if err := w.resync(ctx, updates); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (w *Watcher) resync(ctx context.Context, updates chan<- []discovery.ServerMetadata) error {
+	servers, err := w.client.DiscoverGameServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.servers = make(map[string]discovery.ServerMetadata, len(servers))
+	for _, srv := range servers {
+		w.servers[srv.ContainerID] = srv
+	}
+
+	w.publish(ctx, updates)
+	return nil
+}
+
+// handleEvent applies a single container event and reports whether the
+// server set changed.
+func (w *Watcher) handleEvent(ctx context.Context, msg events.Message) bool {
+	id := msg.Actor.ID
+	_, known := w.servers[id]
+
+	switch msg.Action {
+	case events.ActionDie, actionDied:
+		delete(w.servers, id)
+		return known
+	case events.ActionStart, events.ActionRename, events.ActionUpdate:
+		srv, ok, err := w.client.inspectServer(ctx, id)
+		if err != nil {
+			slog.Warn("Error inspecting container", "id", id, "action", msg.Action, "error", err)
+			return false
+		}
+		if !ok {
+			delete(w.servers, id)
+			return known
+		}
+		w.servers[id] = srv
+		return true
+	}
+
+	return false
+}
+
+func (w *Watcher) publish(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	servers := make([]discovery.ServerMetadata, 0, len(w.servers))
+	for _, srv := range w.servers {
+		servers = append(servers, srv)
+	}
+	// A stable order keeps the owner of a port claimed twice from flipping.
+	slices.SortFunc(servers, func(a, b discovery.ServerMetadata) int {
+		return strings.Compare(a.ServerID, b.ServerID)
+	})
+
+	select {
+	case updates <- servers:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,148 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/vishvananda/netlink"
+)
+
+// AttachMode selects the hook the ingress program runs at. All modes fill
+// the same maps with the same keys and byte counts.
+type AttachMode string
+
+const (
+	// AttachTC adds a filter to a clsact qdisc, which works on any kernel
+	// FlowLens supports.
+	AttachTC AttachMode = "tc"
+	// AttachTCX uses the tcx hook of Linux 6.6 and later. The program is
+	// held by a bpf_link, so it runs next to other TC programs and closing
+	// the link removes exactly what FlowLens attached.
+	AttachTCX AttachMode = "tcx"
+	// AttachXDPNative runs in the driver before an skb is allocated, the
+	// cheapest option on NICs that support it.
+	AttachXDPNative AttachMode = "xdp-native"
+	// AttachXDPGeneric runs XDP on any interface, after skb allocation.
+	AttachXDPGeneric AttachMode = "xdp-generic"
+)
+
+// fallbacks are the modes tried in order for each configured mode, per
+// interface.
+var fallbacks = map[AttachMode][]AttachMode{
+	AttachTC:         {AttachTC},
+	AttachTCX:        {AttachTCX, AttachTC},
+	AttachXDPNative:  {AttachXDPNative, AttachXDPGeneric, AttachTC},
+	AttachXDPGeneric: {AttachXDPGeneric, AttachTC},
+}
+
+func ParseAttachMode(s string) (AttachMode, error) {
+	mode := AttachMode(s)
+	if _, ok := fallbacks[mode]; !ok {
+		return "", fmt.Errorf("invalid attach mode %q, expected tc, tcx, xdp-native or xdp-generic", s)
+	}
+	return mode, nil
+}
+
+// attachment is what FlowLens attached to one interface. links are bpf_links
+// (tcx, XDP) that detach when closed; filters are the tc filters FlowLens
+// added, and qdisc is set when it created the clsact qdisc as well.
+type attachment struct {
+	name    string
+	mode    AttachMode
+	links   []link.Link
+	filters []tcFilter
+	qdisc   bool
+}
+
+// attach attaches the programs to an interface, falling back to the next
+// mode when the configured one is not supported by the kernel or driver.
+// Egress has no XDP hook, so with XDP modes it uses tcx or, failing that, tc.
+func (m *Monitor) attach(l netlink.Link) error {
+	name := l.Attrs().Name
+	configured := m.attachOpts.mode()
+
+	var errs []error
+	for _, mode := range fallbacks[configured] {
+		a := &attachment{name: name, mode: mode}
+		err := m.attachMode(l, a)
+		if err == nil {
+			if mode != configured {
+				slog.Warn("Attach mode not supported, falling back", "interface", name, "mode", configured, "using", mode, "error", errors.Join(errs...))
+			}
+			slog.Debug("Attached to interface", "interface", name, "mode", mode)
+			m.ifaces[l.Attrs().Index] = a
+			return nil
+		}
+		a.detach(l.Attrs().Index)
+		errs = append(errs, fmt.Errorf("%s: %w", mode, err))
+	}
+
+	return fmt.Errorf("failed to attach to %s: %w", name, errors.Join(errs...))
+}
+
+func (m *Monitor) attachMode(l netlink.Link, a *attachment) error {
+	index := l.Attrs().Index
+
+	switch a.mode {
+	case AttachTC:
+		if err := a.attachTC(l, m.attachOpts, netlink.HANDLE_MIN_INGRESS, m.objs.FlowMonitor, "flow_monitor"); err != nil {
+			return err
+		}
+	case AttachTCX:
+		if err := a.attachTCX(index, ebpf.AttachTCXIngress, m.objs.FlowMonitor); err != nil {
+			return err
+		}
+	case AttachXDPNative, AttachXDPGeneric:
+		flags := link.XDPGenericMode
+		if a.mode == AttachXDPNative {
+			flags = link.XDPDriverMode
+		}
+		xdp, err := link.AttachXDP(link.XDPOptions{Program: m.objs.FlowMonitorXdp, Interface: index, Flags: flags})
+		if err != nil {
+			return fmt.Errorf("failed to attach XDP program: %w", err)
+		}
+		a.links = append(a.links, xdp)
+	}
+
+	if !m.attachOpts.Egress {
+		return nil
+	}
+
+	if a.mode == AttachTC {
+		return a.attachTC(l, m.attachOpts, netlink.HANDLE_MIN_EGRESS, m.objs.FlowMonitorEgress, "flow_monitor_egress")
+	}
+	err := a.attachTCX(index, ebpf.AttachTCXEgress, m.objs.FlowMonitorEgress)
+	if err != nil && a.mode != AttachTCX {
+		err = a.attachTC(l, m.attachOpts, netlink.HANDLE_MIN_EGRESS, m.objs.FlowMonitorEgress, "flow_monitor_egress")
+	}
+	return err
+}
+
+// attachTCX attaches prog at the head of the tcx hook, so FlowLens sees
+// every packet before other tcx programs can drop or redirect it.
+func (a *attachment) attachTCX(index int, hook ebpf.AttachType, prog *ebpf.Program) error {
+	tcx, err := link.AttachTCX(link.TCXOptions{Interface: index, Program: prog, Attach: hook, Anchor: link.Head()})
+	if err != nil {
+		return fmt.Errorf("failed to attach tcx program: %w", err)
+	}
+	a.links = append(a.links, tcx)
+	return nil
+}
+
+// detach removes everything FlowLens attached to the interface.
+func (a *attachment) detach(index int) {
+	a.detachTC(index)
+	a.release()
+}
+
+// release closes the bpf_links, which detaches their programs. When the
+// interface is gone the kernel has detached them already.
+func (a *attachment) release() {
+	for _, l := range a.links {
+		l.Close()
+	}
+	a.links = nil
+}
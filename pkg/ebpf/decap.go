@@ -0,0 +1,95 @@
+package ebpf
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// Values of the decap constant in the BPF program.
+const (
+	decapVLAN  uint32 = 0x01
+	decapVXLAN uint32 = 0x02
+	decapGRE   uint32 = 0x04
+	decapIPIP  uint32 = 0x08
+)
+
+// DefaultVXLANPort is the IANA-assigned VXLAN port.
+const DefaultVXLANPort = 4789
+
+// DecapOptions selects the encapsulations peeled to find the client packet.
+// Flows of encapsulated traffic are keyed by the inner address and ports.
+type DecapOptions struct {
+	// VLAN peels up to two 802.1Q or 802.1ad tags, which covers QinQ.
+	VLAN  bool
+	VXLAN bool
+	// GRE peels GRE carrying IP or, as with gretap, Ethernet.
+	GRE bool
+	// IPIP peels IPv4 and IPv6 packets carried directly in IP.
+	IPIP bool
+	// VXLANPort is the UDP port VXLAN is recognised on, DefaultVXLANPort
+	// if zero.
+	VXLANPort uint16
+}
+
+// ParseDecap builds DecapOptions from encapsulation names: vlan, vxlan, gre
+// and ipip.
+func ParseDecap(names []string, vxlanPort uint16) (DecapOptions, error) {
+	d := DecapOptions{VXLANPort: vxlanPort}
+	for _, name := range names {
+		switch name {
+		case "vlan":
+			d.VLAN = true
+		case "vxlan":
+			d.VXLAN = true
+		case "gre":
+			d.GRE = true
+		case "ipip":
+			d.IPIP = true
+		default:
+			return DecapOptions{}, fmt.Errorf("invalid encapsulation %q, expected vlan, vxlan, gre or ipip", name)
+		}
+	}
+	return d, nil
+}
+
+func (d DecapOptions) flags() uint32 {
+	var flags uint32
+	if d.VLAN {
+		flags |= decapVLAN
+	}
+	if d.VXLAN {
+		flags |= decapVXLAN
+	}
+	if d.GRE {
+		flags |= decapGRE
+	}
+	if d.IPIP {
+		flags |= decapIPIP
+	}
+	return flags
+}
+
+func (d DecapOptions) apply(spec *ebpf.CollectionSpec) error {
+	port := d.VXLANPort
+	if port == 0 {
+		port = DefaultVXLANPort
+	}
+
+	vars := map[string]any{
+		"decap":      d.flags(),
+		"vxlan_port": port,
+	}
+
+	for name, value := range vars {
+		vs, ok := spec.Variables[name]
+		if !ok {
+			return fmt.Errorf("variable %s not found in eBPF spec", name)
+		}
+		if err := vs.Set(value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/rxtx-hosting/flowlens/pkg/clock"
+)
+
+// GCStats reports what one GC pass removed from flow_stats.
+type GCStats struct {
+	Scanned     int
+	Stale       int
+	Unmonitored int
+}
+
+func (s GCStats) Removed() int {
+	return s.Stale + s.Unmonitored
+}
+
+// GC deletes flows that have been idle for longer than retention and flows
+// whose destination port is no longer monitored, or no longer monitored with
+// the key mode the flow was recorded in. Egress flows are collected the same
+// way. Port purging waits for an UpdateServers call with every discovery
+// source reported, so a restart does not wipe flows of servers that are
+// still being discovered.
+//
+// Without GC flow_stats only shrinks through LRU eviction, which pushes out
+// live flows first on a busy map.
+func (m *Monitor) GC(retention time.Duration) (GCStats, error) {
+	mono, err := clock.SystemSource().Monotonic()
+	if err != nil {
+		return GCStats{}, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+
+	var cutoff uint64
+	if mono > retention {
+		cutoff = uint64(mono - retention)
+	}
+
+	var stats GCStats
+	if err := m.collect(m.objs.FlowStats, cutoff, &stats); err != nil {
+		return stats, err
+	}
+	if m.attachOpts.Egress {
+		if err := m.collect(m.objs.EgressStats, cutoff, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// collect removes the stale and unmonitored flows of one flow map.
+func (m *Monitor) collect(flows *ebpf.Map, cutoff uint64, stats *GCStats) error {
+	stale := m.staleKeys[:0]
+
+	err := m.scanFlows(flows, func(key FlowKey, info FlowInfo) {
+		stats.Scanned++
+		binding, ok := m.serverMap[int(key.DstPort)]
+		if m.serversKnown && (!ok || binding.SourcePorts != key.SourcePorts()) {
+			stats.Unmonitored++
+			stale = append(stale, key)
+		} else if info.LastSeen < cutoff {
+			stats.Stale++
+			stale = append(stale, key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.staleKeys = stale
+
+	deleted, err := deleteFlows(flows, stale)
+	if flows == m.objs.FlowStats {
+		m.deleted += uint64(deleted)
+	}
+	return err
+}
+
+// deleteFlows removes keys from a flow map in batches and returns how many it
+// deleted. Keys the LRU evicted in the meantime are skipped and not counted,
+// as MapPressure already accounts for them as evictions.
+func deleteFlows(flows *ebpf.Map, keys []FlowKey) (int, error) {
+	deleted := 0
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), flowBatchSize)]
+
+		n, err := flows.BatchDelete(batch, nil)
+		switch {
+		case err == nil:
+			deleted += len(batch)
+			keys = keys[len(batch):]
+		case errors.Is(err, ebpf.ErrKeyNotExist):
+			deleted += n
+			keys = keys[n+1:]
+		case errors.Is(err, ebpf.ErrNotSupported):
+			for _, key := range keys {
+				err := flows.Delete(&key)
+				switch {
+				case err == nil:
+					deleted++
+				case !errors.Is(err, ebpf.ErrKeyNotExist):
+					return deleted, fmt.Errorf("failed to delete flow: %w", err)
+				}
+			}
+			return deleted, nil
+		default:
+			return deleted, fmt.Errorf("failed to batch delete flows: %w", err)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,105 @@
+package ebpf
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// AutoInterface selects the interface that holds the default route.
+const AutoInterface = "auto"
+
+// expandAuto replaces AutoInterface with the name of the default route
+// interface so later matching only has to deal with names and globs.
+func expandAuto(patterns []string) ([]string, error) {
+	expanded := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p != AutoInterface {
+			expanded = append(expanded, p)
+			continue
+		}
+		name, err := defaultRouteInterface()
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, name)
+	}
+	return expanded, nil
+}
+
+func matchInterface(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := filepath.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveInterfaces(patterns []string) ([]netlink.Link, error) {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface pattern %q: %w", p, err)
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	matched := make([]netlink.Link, 0, len(patterns))
+	for _, link := range links {
+		if matchInterface(patterns, link.Attrs().Name) {
+			matched = append(matched, link)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no interface matches %v", patterns)
+	}
+
+	return matched, nil
+}
+
+// defaultRouteInterface returns the interface of the lowest-metric default
+// route in the main table, preferring IPv4 over IPv6.
+func defaultRouteInterface() (string, error) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: unix.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return "", fmt.Errorf("failed to list routes: %w", err)
+		}
+
+		index, priority := 0, -1
+		for _, r := range routes {
+			if r.Dst != nil {
+				if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+					continue
+				}
+			}
+			linkIndex := r.LinkIndex
+			if linkIndex == 0 && len(r.MultiPath) > 0 {
+				linkIndex = r.MultiPath[0].LinkIndex
+			}
+			if linkIndex == 0 {
+				continue
+			}
+			if priority == -1 || r.Priority < priority {
+				index, priority = linkIndex, r.Priority
+			}
+		}
+
+		if index != 0 {
+			link, err := netlink.LinkByIndex(index)
+			if err != nil {
+				return "", fmt.Errorf("failed to get default route interface: %w", err)
+			}
+			return link.Attrs().Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no default route found")
+}
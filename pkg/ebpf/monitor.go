@@ -1,89 +1,207 @@
 package ebpf
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
 
-	"github.com/rxtx-hosting/flowlens/pkg/docker"
+	"github.com/cilium/ebpf"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type flow_key -type flow_info flowMonitor ../../bpf/flow_monitor.c -- -I/usr/include -I/usr/include/x86_64-linux-gnu -O2 -g
 
+const counterInserts uint32 = 0
+
+// Flags stored in monitored_ports.
+const (
+	portMonitored uint8 = 0x01
+	portSrcPort   uint8 = 0x02
+)
+
 type Monitor struct {
-	objs      *flowMonitorObjects
-	iface     string
-	serverMap map[int]string
+	objs         *flowMonitorObjects
+	attachOpts   AttachOptions
+	patterns     []string
+	ifaces       map[int]*attachment
+	ifaceMu      sync.Mutex
+	serverMap    map[int]PortBinding
+	ports        map[uint16]struct{}
+	serversKnown bool
+	flowEntries  int
+	deleted      uint64
+	// baseEntries are the flows found in a reused flow_stats, inserted
+	// before flow_counters was created.
+	baseEntries uint64
+
+	batchKeys        []FlowKey
+	batchValues      []FlowInfo
+	batchUnsupported bool
+	staleKeys        []FlowKey
 }
 
-func NewMonitor(iface string) (*Monitor, error) {
+// NewMonitor loads the BPF objects and attaches the classifier to every
+// interface matching ifaces. Entries may be interface names, glob patterns
+// such as "enp*", or AutoInterface.
+func NewMonitor(ifaces []string, sizes MapSizes, pins PinOptions, attach AttachOptions, decap DecapOptions) (*Monitor, error) {
+	patterns, err := expandAuto(ifaces)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := resolveInterfaces(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := loadFlowMonitor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load eBPF spec: %w", err)
+	}
+
+	if err := sizes.apply(spec, attach.Egress); err != nil {
+		return nil, err
+	}
+
+	if err := decap.apply(spec); err != nil {
+		return nil, err
+	}
+
+	opts, err := pins.apply(spec, attach.pinnedMaps())
+	if err != nil {
+		return nil, err
+	}
+	reused := pins.pinned()
+
 	objs := &flowMonitorObjects{}
-	if err := loadFlowMonitorObjects(objs, nil); err != nil {
+	err = spec.LoadAndAssign(objs, opts)
+	if errors.Is(err, ebpf.ErrMapIncompatible) && pins.ReplaceIncompatible {
+		slog.Warn("Pinned maps do not match this build, discarding flow history", "path", pins.Path, "error", err)
+		if err := RemovePins(pins.Path); err != nil {
+			return nil, err
+		}
+		if opts, err = pins.apply(spec, attach.pinnedMaps()); err != nil {
+			return nil, err
+		}
+		reused = false
+		err = spec.LoadAndAssign(objs, opts)
+	}
+	if errors.Is(err, ebpf.ErrMapIncompatible) {
+		return nil, fmt.Errorf("pinned maps in %s do not match this build, remove them with --cleanup-pins: %w", pins.Path, err)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to load eBPF objects: %w", err)
 	}
+	if reused {
+		slog.Info("Reusing pinned maps", "path", pins.Path)
+	}
 
-	link, err := netlink.LinkByName(iface)
-	if err != nil {
-		objs.Close()
-		return nil, fmt.Errorf("failed to get interface %s: %w", iface, err)
+	m := &Monitor{
+		objs:       objs,
+		attachOpts: attach,
+		patterns:   patterns,
+		ifaces:     make(map[int]*attachment),
+		serverMap:  make(map[int]PortBinding),
 	}
 
-	attrs := netlink.QdiscAttrs{
-		LinkIndex: link.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_CLSACT,
+	if reused {
+		err := m.scanFlows(objs.FlowStats, func(FlowKey, FlowInfo) {
+			m.baseEntries++
+		})
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to count pinned flows: %w", err)
+		}
 	}
 
-	qdisc := &netlink.GenericQdisc{
-		QdiscAttrs: attrs,
-		QdiscType:  "clsact",
+	for _, link := range links {
+		if err := m.attach(link); err != nil {
+			m.Close()
+			return nil, err
+		}
 	}
 
-	if err := netlink.QdiscReplace(qdisc); err != nil {
-		objs.Close()
-		return nil, fmt.Errorf("failed to setup clsact qdisc: %w", err)
+	return m, nil
+}
+
+// Interfaces returns the names of the interfaces the classifier is attached to.
+func (m *Monitor) Interfaces() []string {
+	m.ifaceMu.Lock()
+	defer m.ifaceMu.Unlock()
+
+	names := make([]string, 0, len(m.ifaces))
+	for _, a := range m.ifaces {
+		names = append(names, a.name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	filter := &netlink.BpfFilter{
-		FilterAttrs: netlink.FilterAttrs{
-			LinkIndex: link.Attrs().Index,
-			Parent:    netlink.HANDLE_MIN_INGRESS,
-			Handle:    netlink.MakeHandle(0, 1),
-			Protocol:  3,
-			Priority:  1,
+// WatchLinks attaches the classifier to interfaces matching the configured
+// patterns that appear after startup. It blocks until ctx is cancelled.
+func (m *Monitor) WatchLinks(ctx context.Context) error {
+	updates := make(chan netlink.LinkUpdate)
+	err := netlink.LinkSubscribeWithOptions(updates, ctx.Done(), netlink.LinkSubscribeOptions{
+		ErrorCallback: func(err error) {
+			slog.Error("Link subscription error", "error", err)
 		},
-		Fd:           objs.FlowMonitor.FD(),
-		Name:         "flow_monitor",
-		DirectAction: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to link updates: %w", err)
 	}
 
-	if err := netlink.FilterReplace(filter); err != nil {
-		objs.Close()
-		return nil, fmt.Errorf("failed to attach BPF filter: %w", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return fmt.Errorf("link subscription closed")
+			}
+			m.handleLinkUpdate(update)
+		}
 	}
+}
+
+func (m *Monitor) handleLinkUpdate(update netlink.LinkUpdate) {
+	attrs := update.Link.Attrs()
 
-	return &Monitor{
-		objs:      objs,
-		iface:     iface,
-		serverMap: make(map[int]string),
-	}, nil
+	m.ifaceMu.Lock()
+	defer m.ifaceMu.Unlock()
+
+	a, attached := m.ifaces[attrs.Index]
+
+	switch update.Header.Type {
+	case unix.RTM_DELLINK:
+		if attached {
+			a.release()
+			delete(m.ifaces, attrs.Index)
+			slog.Info("Interface removed", "interface", attrs.Name)
+		}
+	case unix.RTM_NEWLINK:
+		if attached || !matchInterface(m.patterns, attrs.Name) {
+			return
+		}
+		if err := m.attach(update.Link); err != nil {
+			slog.Error("Error attaching to new interface", "interface", attrs.Name, "error", err)
+			return
+		}
+		slog.Info("Attached to new interface", "interface", attrs.Name)
+	}
 }
 
 func (m *Monitor) Close() error {
-	if m.iface != "" {
-		link, err := netlink.LinkByName(m.iface)
-		if err == nil {
-			filter := &netlink.BpfFilter{
-				FilterAttrs: netlink.FilterAttrs{
-					LinkIndex: link.Attrs().Index,
-					Parent:    netlink.HANDLE_MIN_INGRESS,
-					Handle:    netlink.MakeHandle(0, 1),
-					Protocol:  3,
-					Priority:  1,
-				},
-			}
-			netlink.FilterDel(filter)
-		}
+	m.ifaceMu.Lock()
+	for index, a := range m.ifaces {
+		a.detach(index)
 	}
+	m.ifaces = make(map[int]*attachment)
+	m.ifaceMu.Unlock()
 
 	if m.objs != nil {
 		m.objs.Close()
@@ -93,58 +211,150 @@ func (m *Monitor) Close() error {
 
 func (m *Monitor) ReadFlows() (map[FlowKey]FlowInfo, error) {
 	flows := make(map[FlowKey]FlowInfo)
+	if err := m.ReadFlowsInto(flows, nil); err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
 
-	var key FlowKey
-	var val FlowInfo
+// MapPressure reports the fill level of flow_stats as of the last ReadFlows
+// and estimates how many flows the LRU has evicted since FlowLens started.
+// Every insert is counted in BPF; whatever is neither live nor removed by GC,
+// out of those and the flows found in a pinned map at startup, was evicted.
+func (m *Monitor) MapPressure() (MapPressure, error) {
+	var perCPU []uint64
+	if err := m.objs.FlowCounters.Lookup(counterInserts, &perCPU); err != nil {
+		return MapPressure{}, fmt.Errorf("failed to read flow counters: %w", err)
+	}
 
-	iter := m.objs.FlowStats.Iterate()
-	for iter.Next(&key, &val) {
-		flows[key] = val
+	var inserts uint64
+	for _, v := range perCPU {
+		inserts += v
 	}
 
-	if err := iter.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate map: %w", err)
+	p := MapPressure{
+		Entries:  m.flowEntries,
+		Capacity: m.objs.FlowStats.MaxEntries(),
+		Inserts:  inserts,
+		Deleted:  m.deleted,
+	}
+	if p.Capacity > 0 {
+		p.Utilization = float64(p.Entries) / float64(p.Capacity)
+	}
+	if accounted := uint64(p.Entries) + m.deleted; inserts+m.baseEntries > accounted {
+		p.EstimatedEvictions = inserts + m.baseEntries - accounted
 	}
 
-	return flows, nil
+	return p, nil
 }
 
-func (m *Monitor) UpdateServers(servers []docker.ServerMetadata) error {
-	newMap := make(map[int]string)
-	newPorts := make(map[uint16]bool)
+// UpdateServers replaces the monitored ports with those of servers. Flows to
+// the ports of servers in sourcePorts are keyed by source port as well, which
+// tells clients sharing an address apart at the cost of more map entries.
+// complete reports whether servers holds every discovery source; until it
+// does GC leaves flows of unmonitored ports alone.
+//
+// Ports beyond the size of monitored_ports are dropped, in server order, and
+// logged. Stale ports are removed before new ones are added so a full map
+// makes room first.
+func (m *Monitor) UpdateServers(servers []discovery.ServerMetadata, sourcePorts map[string]bool, complete bool) error {
+	newMap := make(map[int]PortBinding)
+	newPorts := make(map[uint16]uint8)
+	capacity := int(m.objs.MonitoredPorts.MaxEntries())
+	dropped := make(map[string]int)
 
 	for _, srv := range servers {
-		newMap[srv.GamePort] = srv.ServerID
-		newPorts[uint16(srv.GamePort)] = true
-	}
-
-	for port := range newPorts {
-		var val uint8 = 1
-		if err := m.objs.MonitoredPorts.Put(&port, &val); err != nil {
-			return fmt.Errorf("failed to add port %d: %w", port, err)
+		flags := portMonitored
+		if sourcePorts[srv.ServerID] {
+			flags |= portSrcPort
+		}
+		for _, r := range srv.Ports {
+			for port := r.Start; port <= r.End; port++ {
+				if _, ok := newPorts[uint16(port)]; !ok && len(newPorts) >= capacity {
+					dropped[srv.ServerID]++
+					continue
+				}
+				newMap[port] = PortBinding{ServerID: srv.ServerID, Role: r.Role, SourcePorts: sourcePorts[srv.ServerID]}
+				newPorts[uint16(port)] = flags
+			}
 		}
 	}
+	if len(dropped) > 0 {
+		slog.Warn("Monitored ports exceed ebpf_ports_map_size, not monitoring the rest", "capacity", capacity, "dropped", dropped)
+	}
 
+	var stale []uint16
 	var oldPort uint16
 	var oldVal uint8
 	iter := m.objs.MonitoredPorts.Iterate()
 	for iter.Next(&oldPort, &oldVal) {
-		if !newPorts[oldPort] {
-			if err := m.objs.MonitoredPorts.Delete(&oldPort); err != nil {
-				return fmt.Errorf("failed to remove port %d: %w", oldPort, err)
-			}
+		if _, ok := newPorts[oldPort]; !ok {
+			stale = append(stale, oldPort)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to iterate monitored ports: %w", err)
+	}
+
+	for _, port := range stale {
+		if err := m.objs.MonitoredPorts.Delete(&port); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to remove port %d: %w", port, err)
+		}
+	}
+
+	for port, flags := range newPorts {
+		if err := m.objs.MonitoredPorts.Put(&port, &flags); err != nil {
+			return fmt.Errorf("failed to add port %d: %w", port, err)
 		}
 	}
 
 	m.serverMap = newMap
+	m.ports = make(map[uint16]struct{}, len(newPorts))
+	for port := range newPorts {
+		m.ports[port] = struct{}{}
+	}
+	if complete {
+		m.serversKnown = true
+	}
 	return nil
 }
 
 func (m *Monitor) GetServerID(port int) (string, bool) {
-	id, ok := m.serverMap[port]
-	return id, ok
+	binding, ok := m.serverMap[port]
+	return binding.ServerID, ok
+}
+
+// MonitoredPorts returns the ports set by the last UpdateServers, for use in a
+// FlowFilter. It is nil before the first update.
+func (m *Monitor) MonitoredPorts() map[uint16]struct{} {
+	return m.ports
 }
 
-func (m *Monitor) GetServerMap() map[int]string {
+func (m *Monitor) GetServerMap() map[int]PortBinding {
 	return m.serverMap
 }
+
+func (s MapSizes) apply(spec *ebpf.CollectionSpec, egress bool) error {
+	sizes := map[string]uint32{
+		"flow_stats":      s.FlowStats,
+		"egress_stats":    s.FlowStats,
+		"monitored_ports": s.MonitoredPorts,
+	}
+	if !egress {
+		// The egress program is loaded but never attached.
+		sizes["egress_stats"] = 1
+	}
+
+	for name, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		ms, ok := spec.Maps[name]
+		if !ok {
+			return fmt.Errorf("map %s not found in eBPF spec", name)
+		}
+		ms.MaxEntries = size
+	}
+
+	return nil
+}
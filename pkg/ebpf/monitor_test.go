@@ -0,0 +1,75 @@
+package ebpf
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+func portsMonitor(t *testing.T, capacity uint32) *Monitor {
+	t.Helper()
+
+	ports, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    2,
+		ValueSize:  1,
+		MaxEntries: capacity,
+	})
+	if err != nil {
+		t.Skipf("cannot create BPF map: %v", err)
+	}
+	t.Cleanup(func() { ports.Close() })
+
+	return &Monitor{objs: &flowMonitorObjects{flowMonitorMaps: flowMonitorMaps{MonitoredPorts: ports}}}
+}
+
+func mapPorts(t *testing.T, m *Monitor) []uint16 {
+	t.Helper()
+
+	var ports []uint16
+	var port uint16
+	var flags uint8
+	iter := m.objs.MonitoredPorts.Iterate()
+	for iter.Next(&port, &flags) {
+		ports = append(ports, port)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+func TestUpdateServersCapacity(t *testing.T) {
+	m := portsMonitor(t, 4)
+	server := func(id string, start, end int) discovery.ServerMetadata {
+		return discovery.ServerMetadata{ServerID: id, Ports: []discovery.PortRange{{Start: start, End: end, Role: discovery.RoleGame}}}
+	}
+
+	// Ports beyond the map size are dropped instead of failing the update.
+	if err := m.UpdateServers([]discovery.ServerMetadata{server("a", 1000, 1002), server("b", 2000, 2001)}, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{1000, 1001, 1002, 2000}
+	if got := mapPorts(t, m); !slices.Equal(got, want) {
+		t.Errorf("map ports = %v, want %v", got, want)
+	}
+	if got := slices.Sorted(maps.Keys(m.MonitoredPorts())); !slices.Equal(got, want) {
+		t.Errorf("monitored ports = %v, want %v", got, want)
+	}
+	if _, ok := m.GetServerID(2001); ok {
+		t.Error("dropped port 2001 is bound to a server")
+	}
+
+	// A full map makes room for the new ports by removing stale ones first.
+	if err := m.UpdateServers([]discovery.ServerMetadata{server("b", 2000, 2003)}, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	want = []uint16{2000, 2001, 2002, 2003}
+	if got := mapPorts(t, m); !slices.Equal(got, want) {
+		t.Errorf("map ports after update = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultPinPath is where maps are pinned unless configured otherwise.
+const DefaultPinPath = "/sys/fs/bpf/flowlens"
+
+// pinnedMaps are the maps kept on bpffs. flow_counters is not pinned: GC
+// deletes are only counted in process, so the eviction estimate starts over
+// with the flows found in a reused flow_stats as its baseline.
+var pinnedMaps = []string{"flow_stats", "egress_stats", "monitored_ports"}
+
+// PinOptions controls pinning of the BPF maps on bpffs so flow history
+// survives a restart. An empty Path disables pinning.
+type PinOptions struct {
+	Path string
+	// ReplaceIncompatible discards pinned maps whose type, key/value size,
+	// size or flags differ from this build instead of failing to start.
+	ReplaceIncompatible bool
+}
+
+// apply pins the maps in names. Maps of pinnedMaps left out, such as
+// egress_stats without egress monitoring, are loaded unpinned and a pin left
+// by an earlier run is removed, so it neither holds memory nor makes the
+// other pinned maps incompatible.
+func (p PinOptions) apply(spec *ebpf.CollectionSpec, names []string) (*ebpf.CollectionOptions, error) {
+	if p.Path == "" {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(p.Path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin path %s: %w", p.Path, err)
+	}
+
+	for _, name := range pinnedMaps {
+		ms, ok := spec.Maps[name]
+		if !ok {
+			return nil, fmt.Errorf("map %s not found in eBPF spec", name)
+		}
+		if slices.Contains(names, name) {
+			ms.Pinning = ebpf.PinByName
+			continue
+		}
+		ms.Pinning = ebpf.PinNone
+		if err := os.Remove(filepath.Join(p.Path, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove pinned map %s: %w", name, err)
+		}
+	}
+
+	return &ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: p.Path},
+	}, nil
+}
+
+func (p PinOptions) pinned() bool {
+	if p.Path == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(p.Path, "flow_stats"))
+	return err == nil
+}
+
+// RemovePins deletes the maps FlowLens pinned under path. The kernel frees a
+// map once the pin is gone and no program or process holds it.
+func RemovePins(path string) error {
+	for _, name := range pinnedMaps {
+		if err := os.Remove(filepath.Join(path, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove pinned map %s: %w", name, err)
+		}
+	}
+
+	// Only succeeds once the directory is empty, which is what we want.
+	os.Remove(path)
+	return nil
+}
@@ -0,0 +1,115 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// flowBatchSize is the number of entries fetched or deleted per syscall.
+const flowBatchSize = 4096
+
+// FlowFilter narrows a read of flow_stats. The zero value matches every flow.
+type FlowFilter struct {
+	// Ports keeps only flows to these destination ports when non-nil.
+	Ports map[uint16]struct{}
+	// Since keeps only flows seen at or after this bpf_ktime_get_ns() time.
+	Since uint64
+}
+
+func (f *FlowFilter) match(key FlowKey, info FlowInfo) bool {
+	if f == nil {
+		return true
+	}
+	if f.Ports != nil {
+		if _, ok := f.Ports[key.DstPort]; !ok {
+			return false
+		}
+	}
+	return info.LastSeen >= f.Since
+}
+
+// ReadFlowsInto replaces the contents of dst with the flows matching filter.
+// Reusing dst across calls, together with the batch buffers kept by the
+// monitor, keeps the steady-state read free of per-flow allocations; see
+// BenchmarkReadFlowsInto.
+func (m *Monitor) ReadFlowsInto(dst map[FlowKey]FlowInfo, filter *FlowFilter) error {
+	clear(dst)
+
+	entries := 0
+	err := m.scanFlows(m.objs.FlowStats, func(key FlowKey, info FlowInfo) {
+		entries++
+		if filter.match(key, info) {
+			dst[key] = info
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.flowEntries = entries
+	return nil
+}
+
+// ReadEgressInto replaces the contents of dst with the server-to-client flows
+// matching filter. Without AttachOptions.Egress dst is left empty.
+func (m *Monitor) ReadEgressInto(dst map[FlowKey]FlowInfo, filter *FlowFilter) error {
+	clear(dst)
+	if !m.attachOpts.Egress {
+		return nil
+	}
+
+	return m.scanFlows(m.objs.EgressStats, func(key FlowKey, info FlowInfo) {
+		if filter.match(key, info) {
+			dst[key] = info
+		}
+	})
+}
+
+// scanFlows calls fn for every entry of a flow map. It reads the map with
+// BPF_MAP_LOOKUP_BATCH into reusable buffers and falls back to per-entry
+// iteration on kernels without batch support.
+func (m *Monitor) scanFlows(flows *ebpf.Map, fn func(FlowKey, FlowInfo)) error {
+	if m.batchUnsupported {
+		return iterateFlows(flows, fn)
+	}
+
+	if m.batchKeys == nil {
+		m.batchKeys = make([]FlowKey, flowBatchSize)
+		m.batchValues = make([]FlowInfo, flowBatchSize)
+	}
+
+	var cursor ebpf.MapBatchCursor
+	for {
+		n, err := flows.BatchLookup(&cursor, m.batchKeys, m.batchValues, nil)
+		for i := 0; i < n; i++ {
+			fn(m.batchKeys[i], m.batchValues[i])
+		}
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return nil
+		}
+		if errors.Is(err, ebpf.ErrNotSupported) {
+			m.batchUnsupported = true
+			return iterateFlows(flows, fn)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to batch read flows: %w", err)
+		}
+	}
+}
+
+func iterateFlows(flows *ebpf.Map, fn func(FlowKey, FlowInfo)) error {
+	var key FlowKey
+	var val FlowInfo
+
+	iter := flows.Iterate()
+	for iter.Next(&key, &val) {
+		fn(key, val)
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to iterate map: %w", err)
+	}
+	return nil
+}
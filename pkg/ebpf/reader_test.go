@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+const benchFlows = 20000
+
+// benchMonitor returns a Monitor whose flow_stats holds benchFlows fake
+// entries. It needs permission to create BPF maps.
+func benchMonitor(b *testing.B) *Monitor {
+	b.Helper()
+
+	flows, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    uint32(binary.Size(FlowKey{})),
+		ValueSize:  uint32(binary.Size(FlowInfo{})),
+		MaxEntries: benchFlows,
+	})
+	if err != nil {
+		b.Skipf("cannot create BPF map: %v", err)
+	}
+	b.Cleanup(func() { flows.Close() })
+
+	keys := make([]FlowKey, benchFlows)
+	values := make([]FlowInfo, benchFlows)
+	for i := range keys {
+		keys[i].SrcIP[10], keys[i].SrcIP[11] = 0xff, 0xff
+		binary.BigEndian.PutUint32(keys[i].SrcIP[12:], uint32(i))
+		keys[i].DstPort = uint16(27015 + i%16)
+		keys[i].Proto = 17
+		values[i] = FlowInfo{Packets: uint64(i), Bytes: uint64(i) * 100, FirstSeen: 1, LastSeen: uint64(i)}
+	}
+	if _, err := flows.BatchUpdate(keys, values, nil); err != nil {
+		for i := range keys {
+			if err := flows.Put(&keys[i], &values[i]); err != nil {
+				b.Fatalf("failed to fill map: %v", err)
+			}
+		}
+	}
+
+	return &Monitor{objs: &flowMonitorObjects{flowMonitorMaps: flowMonitorMaps{FlowStats: flows}}}
+}
+
+func BenchmarkReadFlows(b *testing.B) {
+	m := benchMonitor(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		flows, err := m.ReadFlows()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(flows) != benchFlows {
+			b.Fatalf("read %d flows, want %d", len(flows), benchFlows)
+		}
+	}
+}
+
+func BenchmarkReadFlowsInto(b *testing.B) {
+	m := benchMonitor(b)
+	dst := make(map[FlowKey]FlowInfo)
+	// The first read sizes dst and the batch buffers.
+	if err := m.ReadFlowsInto(dst, nil); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := m.ReadFlowsInto(dst, nil); err != nil {
+			b.Fatal(err)
+		}
+		if len(dst) != benchFlows {
+			b.Fatalf("read %d flows, want %d", len(dst), benchFlows)
+		}
+	}
+}
+
+func BenchmarkReadFlowsIntoFiltered(b *testing.B) {
+	m := benchMonitor(b)
+	dst := make(map[FlowKey]FlowInfo)
+	filter := &FlowFilter{Ports: map[uint16]struct{}{27015: {}}}
+	if err := m.ReadFlowsInto(dst, filter); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := m.ReadFlowsInto(dst, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIterateFlows(b *testing.B) {
+	m := benchMonitor(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		n := 0
+		if err := iterateFlows(m.objs.FlowStats, func(FlowKey, FlowInfo) { n++ }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
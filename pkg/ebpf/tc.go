@@ -0,0 +1,197 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/cilium/ebpf"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// TCConflict decides what happens when another filter already holds the tc
+// priority FlowLens is configured to use.
+type TCConflict string
+
+const (
+	// TCConflictRefuse fails the tc attachment and leaves the filter alone.
+	TCConflictRefuse TCConflict = "refuse"
+	// TCConflictChain adds the FlowLens filter at the highest free priority
+	// below the lowest taken one, so it runs before the existing filters.
+	TCConflictChain TCConflict = "chain"
+)
+
+func ParseTCConflict(s string) (TCConflict, error) {
+	switch c := TCConflict(s); c {
+	case TCConflictRefuse, TCConflictChain:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid tc conflict policy %q, expected refuse or chain", s)
+}
+
+// tcFilter identifies a filter FlowLens added. progID tells it apart from a
+// filter someone else has put in its place since.
+type tcFilter struct {
+	attrs  netlink.FilterAttrs
+	progID int
+}
+
+// attachTC adds prog as a direct-action filter on parent of the clsact qdisc,
+// creating the qdisc if there is none. Existing filters are never replaced,
+// except one left behind by an earlier FlowLens that did not shut down
+// cleanly.
+func (a *attachment) attachTC(l netlink.Link, opts AttachOptions, parent uint32, prog *ebpf.Program, progName string) error {
+	name := l.Attrs().Name
+
+	qdisc := clsact(l.Attrs().Index)
+	err := netlink.QdiscAdd(qdisc)
+	switch {
+	case err == nil:
+		a.qdisc = true
+	case !errors.Is(err, unix.EEXIST):
+		return fmt.Errorf("failed to add clsact qdisc on %s: %w", name, err)
+	}
+
+	existing, err := netlink.FilterList(l, parent)
+	if err != nil {
+		return fmt.Errorf("failed to list tc filters on %s: %w", name, err)
+	}
+
+	attrs, leftover, err := placeFilter(existing, opts, l.Attrs().Index, parent, progName)
+	if err != nil {
+		return fmt.Errorf("failed to attach BPF filter %s to %s: %w", progName, name, err)
+	}
+
+	info, err := prog.Info()
+	if err != nil {
+		return fmt.Errorf("failed to get program info: %w", err)
+	}
+	progID, _ := info.ID()
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs:  attrs,
+		Fd:           prog.FD(),
+		Name:         progName,
+		DirectAction: true,
+	}
+
+	add := netlink.FilterAdd
+	if leftover {
+		slog.Warn("Replacing tc filter left behind by an earlier FlowLens", "interface", name, "filter", progName, "priority", attrs.Priority)
+		add = netlink.FilterReplace
+	} else if attrs.Priority != opts.tcPriority() {
+		slog.Info("Tc priority taken, placing filter before existing filters", "interface", name, "filter", progName, "priority", attrs.Priority)
+	}
+
+	if err := add(filter); err != nil {
+		return fmt.Errorf("failed to attach BPF filter %s to %s: %w", progName, name, err)
+	}
+	a.filters = append(a.filters, tcFilter{attrs: attrs, progID: int(progID)})
+	return nil
+}
+
+// placeFilter picks the priority and handle for a FlowLens filter among the
+// existing filters of a hook. leftover is set when a FlowLens filter of the
+// same name is already there and should be replaced in place.
+func placeFilter(existing []netlink.Filter, opts AttachOptions, index int, parent uint32, progName string) (attrs netlink.FilterAttrs, leftover bool, err error) {
+	attrs = netlink.FilterAttrs{
+		LinkIndex: index,
+		Parent:    parent,
+		Handle:    opts.tcHandle(),
+		Protocol:  unix.ETH_P_ALL,
+		Priority:  opts.tcPriority(),
+	}
+
+	taken := make(map[uint16]bool)
+	for _, f := range existing {
+		if bpf, ok := f.(*netlink.BpfFilter); ok && bpf.Name == progName && bpf.Handle != 0 {
+			attrs.Priority = bpf.Priority
+			attrs.Handle = bpf.Handle
+			attrs.Protocol = bpf.Protocol
+			return attrs, true, nil
+		}
+		taken[f.Attrs().Priority] = true
+	}
+
+	if !taken[attrs.Priority] {
+		return attrs, false, nil
+	}
+	if opts.TCConflict != TCConflictChain {
+		return attrs, false, fmt.Errorf("priority %d is taken by another filter, set tc_priority to a free one or tc_conflict: chain", attrs.Priority)
+	}
+	// Lower priorities run first. Every priority below the lowest taken one
+	// is free, so the search only has to find that one.
+	lowest := attrs.Priority
+	for prio := range taken {
+		lowest = min(lowest, prio)
+	}
+	if lowest <= 1 {
+		return attrs, false, fmt.Errorf("no free priority before the existing filters, which start at %d", lowest)
+	}
+	attrs.Priority = lowest - 1
+	return attrs, false, nil
+}
+
+// detachTC removes the filters FlowLens added, skipping any that have been
+// replaced by someone else since, and the clsact qdisc if FlowLens created it
+// and no other filters were added to it in the meantime.
+func (a *attachment) detachTC(index int) {
+	for _, f := range a.filters {
+		if !ownsFilter(f) {
+			slog.Warn("Tc filter was replaced, leaving it in place", "interface", a.name, "priority", f.attrs.Priority)
+			continue
+		}
+		if err := netlink.FilterDel(&netlink.BpfFilter{FilterAttrs: f.attrs}); err != nil {
+			slog.Error("Error removing tc filter", "interface", a.name, "priority", f.attrs.Priority, "error", err)
+		}
+	}
+	a.filters = nil
+
+	if !a.qdisc {
+		return
+	}
+	a.qdisc = false
+
+	l, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return
+	}
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		if filters, err := netlink.FilterList(l, parent); err != nil || len(filters) > 0 {
+			return
+		}
+	}
+	if err := netlink.QdiscDel(clsact(index)); err != nil {
+		slog.Error("Error removing clsact qdisc", "interface", a.name, "error", err)
+	}
+}
+
+func ownsFilter(f tcFilter) bool {
+	l, err := netlink.LinkByIndex(f.attrs.LinkIndex)
+	if err != nil {
+		return false
+	}
+	filters, err := netlink.FilterList(l, f.attrs.Parent)
+	if err != nil {
+		return false
+	}
+	for _, existing := range filters {
+		bpf, ok := existing.(*netlink.BpfFilter)
+		if ok && bpf.Priority == f.attrs.Priority && bpf.Handle == f.attrs.Handle {
+			return bpf.Id == f.progID
+		}
+	}
+	return false
+}
+
+func clsact(index int) *netlink.GenericQdisc {
+	return &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+}
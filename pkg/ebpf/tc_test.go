@@ -0,0 +1,71 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestPlaceFilter(t *testing.T) {
+	const progName = "flow_monitor"
+
+	filter := func(name string, prio uint16, handle uint32) netlink.Filter {
+		return &netlink.BpfFilter{
+			FilterAttrs: netlink.FilterAttrs{Priority: prio, Handle: handle},
+			Name:        name,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		existing     []netlink.Filter
+		opts         AttachOptions
+		wantPrio     uint16
+		wantLeftover bool
+		wantErr      bool
+	}{
+		{name: "empty hook", wantPrio: 1},
+		{name: "configured priority free", existing: []netlink.Filter{filter("other", 5, 1)}, opts: AttachOptions{TCPriority: 10}, wantPrio: 10},
+		{name: "taken refuses", existing: []netlink.Filter{filter("other", 10, 1)}, opts: AttachOptions{TCPriority: 10}, wantErr: true},
+		{
+			name:     "chain runs before the taken priority",
+			existing: []netlink.Filter{filter("other", 10, 1), filter("other", 20, 1)},
+			opts:     AttachOptions{TCPriority: 10, TCConflict: TCConflictChain},
+			wantPrio: 9,
+		},
+		{
+			name:     "chain runs before every filter",
+			existing: []netlink.Filter{filter("other", 3, 1), filter("other", 10, 1)},
+			opts:     AttachOptions{TCPriority: 10, TCConflict: TCConflictChain},
+			wantPrio: 2,
+		},
+		{
+			name:     "chain without room",
+			existing: []netlink.Filter{filter("other", 1, 1)},
+			opts:     AttachOptions{TCConflict: TCConflictChain},
+			wantErr:  true,
+		},
+		{
+			name:         "leftover is replaced in place",
+			existing:     []netlink.Filter{filter("other", 1, 1), filter(progName, 7, 3)},
+			opts:         AttachOptions{TCPriority: 1},
+			wantPrio:     7,
+			wantLeftover: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, leftover, err := placeFilter(tt.existing, tt.opts, 2, netlink.HANDLE_MIN_INGRESS, progName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if attrs.Priority != tt.wantPrio || leftover != tt.wantLeftover {
+				t.Errorf("priority, leftover = %d, %v, want %d, %v", attrs.Priority, leftover, tt.wantPrio, tt.wantLeftover)
+			}
+		})
+	}
+}
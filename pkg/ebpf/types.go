@@ -1,14 +1,117 @@
 package ebpf
 
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+// FlowKey mirrors struct flow_key in bpf/flow_monitor.c. IPv4 sources are
+// stored as IPv4-mapped IPv6 addresses. SrcPort is zero unless the server
+// the port belongs to uses source port keys, which sets FlowSrcPort in Flags.
 type FlowKey struct {
-	SrcIP   uint32
+	SrcIP   [16]byte
 	DstPort uint16
+	SrcPort uint16
 	Proto   uint8
-	_       uint8
+	Flags   uint8
+}
+
+// FlowSrcPort is set in FlowKey.Flags by the BPF program when the flow is
+// keyed by source port.
+const FlowSrcPort uint8 = 0x01
+
+// SourcePorts reports whether the flow was recorded with its source port.
+func (k FlowKey) SourcePorts() bool {
+	return k.Flags&FlowSrcPort != 0
+}
+
+// Addr returns the source address, unmapped to a plain IPv4 address when the
+// flow came from an IPv4 client.
+func (k FlowKey) Addr() netip.Addr {
+	return netip.AddrFrom16(k.SrcIP).Unmap()
 }
 
+// FlowInfo mirrors struct flow_info. FirstSeen and LastSeen are
+// bpf_ktime_get_ns() timestamps.
 type FlowInfo struct {
-	Packets  uint64
-	Bytes    uint64
-	LastSeen uint64
+	Packets   uint64
+	Bytes     uint64
+	FirstSeen uint64
+	LastSeen  uint64
+}
+
+// AttachOptions controls which programs are attached to each interface and
+// how.
+type AttachOptions struct {
+	// Mode is the hook for ingress traffic, AttachTCX if empty.
+	Mode AttachMode
+	// Egress attaches a second program on egress that records
+	// server-to-client traffic, see ReadEgressInto.
+	Egress bool
+	// TCPriority and TCHandle place the filters of the tc mode, 1 if zero.
+	TCPriority uint16
+	TCHandle   uint32
+	// TCConflict decides what happens when another filter holds
+	// TCPriority, TCConflictRefuse if empty.
+	TCConflict TCConflict
+}
+
+func (o AttachOptions) mode() AttachMode {
+	if o.Mode == "" {
+		return AttachTCX
+	}
+	return o.Mode
+}
+
+// pinnedMaps returns the maps to pin with these options. egress_stats is
+// only pinned when egress is monitored.
+func (o AttachOptions) pinnedMaps() []string {
+	if o.Egress {
+		return pinnedMaps
+	}
+	return slices.DeleteFunc(slices.Clone(pinnedMaps), func(name string) bool {
+		return name == "egress_stats"
+	})
+}
+
+func (o AttachOptions) tcPriority() uint16 {
+	if o.TCPriority == 0 {
+		return 1
+	}
+	return o.TCPriority
+}
+
+func (o AttachOptions) tcHandle() uint32 {
+	if o.TCHandle == 0 {
+		return 1
+	}
+	return o.TCHandle
+}
+
+// MapSizes overrides the max_entries of the BPF maps before they are loaded.
+// Zero keeps the size compiled into the object. egress_stats is sized like
+// flow_stats, or to a single entry when egress is not monitored.
+type MapSizes struct {
+	FlowStats      uint32
+	MonitoredPorts uint32
+}
+
+// MapPressure describes how full the flow_stats LRU map is.
+type MapPressure struct {
+	Entries            int
+	Capacity           uint32
+	Utilization        float64
+	Inserts            uint64
+	Deleted            uint64
+	EstimatedEvictions uint64
+}
+
+// PortBinding is the server and role a monitored port belongs to.
+// SourcePorts is set when flows to the port are keyed by source port too.
+type PortBinding struct {
+	ServerID    string
+	Role        discovery.PortRole
+	SourcePorts bool
 }
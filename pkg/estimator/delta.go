@@ -0,0 +1,73 @@
+package estimator
+
+import "github.com/rxtx-hosting/flowlens/pkg/ebpf"
+
+// flowSample is the traffic of one flow between two ticks, stamped with the
+// kernel monotonic time and number of the later tick.
+type flowSample struct {
+	at      uint64
+	tick    uint64
+	packets uint64
+	bytes   uint64
+}
+
+// flowState is what the estimator remembers about a flow between ticks: the
+// counters of the last snapshot and the per-tick deltas still inside the
+// activity window.
+type flowState struct {
+	prev     ebpf.FlowInfo
+	seen     bool
+	tick     uint64
+	samples  []flowSample
+	dPackets uint64
+	dBytes   uint64
+}
+
+// update stores a new snapshot of the flow and returns the traffic since the
+// previous one. since is the monotonic time of the previous tick, zero on the
+// first.
+//
+// The first snapshot of a flow is only a baseline, as its counters may hold
+// traffic from long ago, e.g. after a restart with pinned maps. It counts in
+// full only if the flow was created after the previous tick. So does a
+// snapshot of an entry that was evicted and re-created, which shows as a new
+// FirstSeen or counters that went backwards.
+func (s *flowState) update(info ebpf.FlowInfo, at, since, tick uint64) (packets, bytes uint64) {
+	switch {
+	case !s.seen:
+		if since != 0 && info.FirstSeen > since {
+			packets, bytes = info.Packets, info.Bytes
+		}
+	case info.FirstSeen == s.prev.FirstSeen && info.Packets >= s.prev.Packets && info.Bytes >= s.prev.Bytes:
+		packets, bytes = info.Packets-s.prev.Packets, info.Bytes-s.prev.Bytes
+	default:
+		packets, bytes = info.Packets, info.Bytes
+	}
+
+	s.prev = info
+	s.seen = true
+	s.tick = tick
+	s.dPackets, s.dBytes = packets, bytes
+
+	if packets > 0 || bytes > 0 {
+		s.samples = append(s.samples, flowSample{at: at, tick: tick, packets: packets, bytes: bytes})
+	}
+	return packets, bytes
+}
+
+// recent drops samples older than cutoff and sums the rest.
+func (s *flowState) recent(cutoff uint64) (packets, bytes uint64) {
+	drop := 0
+	for drop < len(s.samples) && s.samples[drop].at < cutoff {
+		drop++
+	}
+	if drop > 0 {
+		s.samples = append(s.samples[:0], s.samples[drop:]...)
+	}
+
+	for _, sample := range s.samples {
+		packets += sample.packets
+		bytes += sample.bytes
+	}
+	return packets, bytes
+}
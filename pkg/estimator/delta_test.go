@@ -0,0 +1,80 @@
+package estimator
+
+import (
+	"testing"
+
+	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
+)
+
+func TestFlowStateUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		seen        bool
+		prev        ebpf.FlowInfo
+		info        ebpf.FlowInfo
+		since       uint64
+		wantPackets uint64
+		wantBytes   uint64
+	}{
+		{
+			name:  "first tick is a baseline",
+			info:  ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 100},
+			since: 0,
+		},
+		{
+			name:  "flow from before the previous tick is a baseline",
+			info:  ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 100},
+			since: 200,
+		},
+		{
+			name:        "flow created since the previous tick counts in full",
+			info:        ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 300},
+			since:       200,
+			wantPackets: 50,
+			wantBytes:   5000,
+		},
+		{
+			name:        "known flow counts the delta",
+			seen:        true,
+			prev:        ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 100},
+			info:        ebpf.FlowInfo{Packets: 70, Bytes: 6000, FirstSeen: 100},
+			since:       200,
+			wantPackets: 20,
+			wantBytes:   1000,
+		},
+		{
+			name:        "re-created flow counts in full",
+			seen:        true,
+			prev:        ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 100},
+			info:        ebpf.FlowInfo{Packets: 5, Bytes: 400, FirstSeen: 250},
+			since:       200,
+			wantPackets: 5,
+			wantBytes:   400,
+		},
+		{
+			name:        "re-created flow past the old counters counts in full",
+			seen:        true,
+			prev:        ebpf.FlowInfo{Packets: 50, Bytes: 5000, FirstSeen: 100},
+			info:        ebpf.FlowInfo{Packets: 80, Bytes: 9000, FirstSeen: 250},
+			since:       200,
+			wantPackets: 80,
+			wantBytes:   9000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &flowState{seen: tt.seen, prev: tt.prev}
+			packets, bytes := s.update(tt.info, 400, tt.since, 2)
+			if packets != tt.wantPackets || bytes != tt.wantBytes {
+				t.Fatalf("update() = %d packets, %d bytes, want %d, %d", packets, bytes, tt.wantPackets, tt.wantBytes)
+			}
+			if got, _ := s.recent(0); got != tt.wantPackets {
+				t.Errorf("recent() = %d packets, want %d", got, tt.wantPackets)
+			}
+			if s.prev != tt.info {
+				t.Errorf("prev = %+v, want %+v", s.prev, tt.info)
+			}
+		})
+	}
+}
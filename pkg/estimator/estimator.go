@@ -1,135 +1,464 @@
 package estimator
 
 import (
-	"encoding/binary"
+	"cmp"
 	"log/slog"
-	"net"
-	"os"
-	"strconv"
-	"strings"
+	"net/netip"
+	"slices"
 	"time"
 
+	"github.com/rxtx-hosting/flowlens/pkg/clock"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
 	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
 )
 
-type Estimator struct {
-	activityThreshold   time.Duration
-	minPacketsThreshold uint64
-	minBytesThreshold   uint64
+// maxIntervals bounds the per-interval history kept for strategies.
+const maxIntervals = 32
+
+// reconnectOverlap is how far a new source port may overlap the last packet
+// of an old one from the same address and still count as that client
+// reconnecting, in nanoseconds.
+const reconnectOverlap = uint64(time.Second)
+
+// Engine turns flow snapshots into per-server player estimates. It tracks
+// per-flow deltas between ticks, hands each server's clients to the
+// configured strategies and keeps player sessions.
+type Engine struct {
+	defaults      Params
+	serverParams  map[string]Params
+	clock         *clock.Correlator
+	sessions      *SessionTracker
+	sessionUpdate SessionUpdate
+	flows         map[ebpf.FlowKey]*flowState
+	egress        map[ebpf.FlowKey]*flowState
+	clients       map[string][]ClientActivity
+	intervals     []tickInterval
+	lastTick      time.Time
+	lastMono      uint64
+	tick          uint64
+}
+
+// tickInterval is the length of the metrics interval ending at tick, which
+// was taken at kernel monotonic time at.
+type tickInterval struct {
+	tick    uint64
+	at      uint64
+	seconds float64
+}
+
+func NewEngine(defaults Params, clk *clock.Correlator) *Engine {
+	return &Engine{
+		defaults:     defaults,
+		serverParams: make(map[string]Params),
+		clock:        clk,
+		sessions:     NewSessionTracker(),
+		flows:        make(map[ebpf.FlowKey]*flowState),
+		egress:       make(map[ebpf.FlowKey]*flowState),
+		clients:      make(map[string][]ClientActivity),
+	}
+}
+
+// SetServerParams replaces the per-server parameters, keyed by server ID.
+// Servers without an entry use the defaults.
+func (e *Engine) SetServerParams(params map[string]Params) {
+	e.serverParams = params
 }
 
-func NewEstimator(activityThreshold time.Duration, minPackets, minBytes uint64) *Estimator {
-	return &Estimator{
-		activityThreshold:   activityThreshold,
-		minPacketsThreshold: minPackets,
-		minBytesThreshold:   minBytes,
+// Params returns the parameters in effect for a server.
+func (e *Engine) Params(serverID string) Params {
+	if p, ok := e.serverParams[serverID]; ok {
+		return p
 	}
+	return e.defaults
+}
+
+// Clients returns the game clients of a server as of the last
+// EstimatePlayers call, players or not.
+func (e *Engine) Clients(serverID string) []ClientActivity {
+	return e.clients[serverID]
+}
+
+// Baseline reports whether the last EstimatePlayers call was the first. It
+// only records the baseline counters of every flow, so its estimates are
+// empty rather than measured.
+func (e *Engine) Baseline() bool {
+	return e.tick <= 1
+}
+
+// SessionUpdate returns the session changes of the last EstimatePlayers call.
+func (e *Engine) SessionUpdate() SessionUpdate {
+	return e.sessionUpdate
+}
+
+// serverFlows collects the clients of one server. Game-role ports share one
+// client set so a client seen on several game ports counts once; every other
+// role is tracked on its own. Game traffic is kept per source port, which is
+// zero unless the server keys flows by source port.
+type serverFlows struct {
+	params           Params
+	cutoff           uint64
+	endpoints        map[netip.Addr]map[uint16]*clientFlows
+	roles            map[discovery.PortRole]map[netip.Addr]uint64
+	uploadBytes      uint64
+	downloadBytes    uint64
+	packetsPerSecond float64
+	bytesPerSecond   float64
+}
+
+type clientFlows struct {
+	activity ClientActivity
+	byTick   map[uint64]flowSample
 }
 
-func (e *Estimator) EstimatePlayers(flows map[ebpf.FlowKey]ebpf.FlowInfo, serverMap map[int]string) []ServerPlayerStats {
-	bootTime, err := getBootTime()
-	if err != nil {
+// EstimatePlayers estimates the players of every server from the client
+// flows and, if egress is monitored, the server's replies to them in egress.
+// egress may be nil.
+func (e *Engine) EstimatePlayers(flows, egress map[ebpf.FlowKey]ebpf.FlowInfo, serverMap map[int]ebpf.PortBinding) []ServerPlayerStats {
+	if err := e.clock.Sync(); err != nil {
+		slog.Error("Error reading clocks", "error", err)
 		return []ServerPlayerStats{}
 	}
 
-	nowUnix := time.Now().UnixNano()
-	nowSinceBoot := uint64(nowUnix - bootTime)
-	cutoff := nowSinceBoot - uint64(e.activityThreshold.Nanoseconds())
+	now := e.clock.Now()
+	nowMono := e.clock.Monotonic()
 
-	portFlows := make(map[int]map[string]uint64)
+	var elapsed float64
+	if !e.lastTick.IsZero() {
+		elapsed = now.Sub(e.lastTick).Seconds()
+	}
+	since := e.lastMono
+	e.lastTick = now
+	e.lastMono = nowMono
+	e.tick++
 
-	var totalFlows, timeFiltered, thresholdFiltered, portFiltered, passed int
-	var sampleCount int
+	// The first tick only establishes the baseline counters, so it is not
+	// an interval strategies can measure a rate over.
+	if elapsed > 0 {
+		e.intervals = append([]tickInterval{{tick: e.tick, at: nowMono, seconds: elapsed}}, e.intervals...)
+		e.intervals = e.intervals[:min(len(e.intervals), maxIntervals)]
+	}
+
+	servers := make(map[string]*serverFlows)
+	server := func(serverID string) *serverFlows {
+		sf := servers[serverID]
+		if sf == nil {
+			params := e.Params(serverID)
+			sf = &serverFlows{
+				params:    params,
+				cutoff:    e.clock.FromWall(now.Add(-params.Activity)),
+				endpoints: make(map[netip.Addr]map[uint16]*clientFlows),
+				roles:     make(map[discovery.PortRole]map[netip.Addr]uint64),
+			}
+			servers[serverID] = sf
+		}
+		return sf
+	}
+
+	var totalFlows, timeFiltered, portFiltered int
 
 	for key, info := range flows {
 		totalFlows++
 
-		if info.LastSeen < cutoff {
+		ip := key.Addr()
+		port := int(key.DstPort)
+
+		binding, exists := serverMap[port]
+		if !exists {
+			portFiltered++
+			continue
+		}
+
+		sf := server(binding.ServerID)
+
+		st := e.flows[key]
+		if st == nil {
+			st = &flowState{}
+			e.flows[key] = st
+		}
+		dPackets, dBytes := st.update(info, nowMono, since, e.tick)
+		packets, bytes := st.recent(sf.cutoff)
+
+		if info.LastSeen < sf.cutoff {
 			timeFiltered++
 			continue
 		}
 
-		ip := uint32ToIP(key.SrcIP)
-		port := int(key.DstPort)
+		sf.uploadBytes += bytes
+		if elapsed > 0 {
+			sf.packetsPerSecond += float64(dPackets) / elapsed
+			sf.bytesPerSecond += float64(dBytes) / elapsed
+		}
 
-		if info.Packets < e.minPacketsThreshold || info.Bytes < e.minBytesThreshold {
-			thresholdFiltered++
-			if sampleCount < 5 {
-				slog.Debug("Flow filtered", "ip", ip, "port", port, "packets", info.Packets, "bytes", info.Bytes, "reason", "below threshold")
-				sampleCount++
+		// Query, RCON and voice traffic is low volume by nature, so only
+		// game ports are considered for players.
+		if binding.Role != discovery.RoleGame {
+			if sf.roles[binding.Role] == nil {
+				sf.roles[binding.Role] = make(map[netip.Addr]uint64)
 			}
+			sf.roles[binding.Role][ip] += bytes
 			continue
 		}
 
-		if _, exists := serverMap[port]; !exists {
-			portFiltered++
+		if sf.endpoints[ip] == nil {
+			sf.endpoints[ip] = make(map[uint16]*clientFlows)
+		}
+		cf := sf.endpoints[ip][key.SrcPort]
+		if cf == nil {
+			cf = newClientFlows(ip, key.SrcPort, info.FirstSeen)
+			sf.endpoints[ip][key.SrcPort] = cf
+		}
+		cf.add(packets, bytes, info.FirstSeen, info.LastSeen)
+		for _, sample := range st.samples {
+			cf.addSample(sample)
+		}
+	}
+
+	// Replies are keyed like the flows they answer, so they are matched to
+	// the client endpoint that was sent to.
+	for key, info := range egress {
+		binding, exists := serverMap[int(key.DstPort)]
+		if !exists {
+			continue
+		}
+		sf := server(binding.ServerID)
+
+		st := e.egress[key]
+		if st == nil {
+			st = &flowState{}
+			e.egress[key] = st
+		}
+		st.update(info, nowMono, since, e.tick)
+		packets, bytes := st.recent(sf.cutoff)
+		if info.LastSeen < sf.cutoff {
 			continue
 		}
 
-		if portFlows[port] == nil {
-			portFlows[port] = make(map[string]uint64)
+		sf.downloadBytes += bytes
+		if binding.Role != discovery.RoleGame {
+			continue
+		}
+		if cf := sf.endpoints[key.Addr()][key.SrcPort]; cf != nil {
+			cf.activity.DownloadPackets += packets
+			cf.activity.DownloadBytes += bytes
 		}
+	}
 
-		portFlows[port][ip] += info.Bytes
-		if passed < 5 {
-			slog.Debug("Flow passed", "ip", ip, "port", port, "packets", info.Packets, "bytes", info.Bytes)
+	for key, st := range e.flows {
+		if st.tick != e.tick {
+			delete(e.flows, key)
 		}
-		passed++
 	}
+	for key, st := range e.egress {
+		if st.tick != e.tick {
+			delete(e.egress, key)
+		}
+	}
+
+	slog.Debug("Flow filtering complete", "total", totalFlows, "timeFiltered", timeFiltered, "portFiltered", portFiltered)
+
+	stats := make([]ServerPlayerStats, 0, len(servers))
+	e.clients = make(map[string][]ClientActivity, len(servers))
+	seen := make(map[string]map[netip.Addr]playerSeen, len(servers))
+
+	for serverID, sf := range servers {
+		if len(sf.endpoints) == 0 && len(sf.roles) == 0 && sf.packetsPerSecond == 0 && sf.downloadBytes == 0 {
+			continue
+		}
+
+		byClient := make(map[netip.AddrPort]ClientActivity)
+		clients := make([]ClientActivity, 0, len(sf.endpoints))
+		for _, endpoints := range sf.endpoints {
+			for _, cf := range groupEndpoints(endpoints) {
+				activity := e.withIntervals(cf, sf.cutoff)
+				// A client the server does not answer is talking to a
+				// server that is down or ignoring it, not playing.
+				if sf.params.Bidirectional && activity.DownloadPackets == 0 {
+					continue
+				}
+				byClient[netip.AddrPortFrom(activity.Addr, activity.Port)] = activity
+				clients = append(clients, activity)
+			}
+		}
+		e.clients[serverID] = clients
 
-	slog.Debug("Flow filtering complete", "total", totalFlows, "timeFiltered", timeFiltered, "thresholdFiltered", thresholdFiltered, "portFiltered", portFiltered, "passed", passed, "minPackets", e.minPacketsThreshold, "minBytes", e.minBytesThreshold)
+		strategy, err := Lookup(sf.params.Strategy)
+		if err != nil {
+			slog.Warn("Falling back to the default strategy", "server_id", serverID, "error", err)
+			strategy = registry[DefaultStrategy]
+		}
 
-	stats := make([]ServerPlayerStats, 0, len(portFlows))
+		results := make(map[string]StrategyResult, len(registry))
+		var selected Estimate
+		for name, s := range registry {
+			est := s.Estimate(clients, sf.params)
+			results[name] = StrategyResult{Players: len(est.Players), Confidence: est.Confidence}
+			if name == strategy.Name() {
+				selected = est
+			}
+		}
 
-	for port, ipMap := range portFlows {
-		serverID := serverMap[port]
-		uniqueIPs := make([]string, 0, len(ipMap))
+		var uniqueIPs []string
 		var totalBytes uint64
+		var ipv4Players, ipv6Players int
+
+		if len(selected.Players) > 0 {
+			seen[serverID] = make(map[netip.Addr]playerSeen, len(selected.Players))
+		}
+
+		// Sessions are tracked per address, since the clients behind one
+		// are told apart only by source ports that change on reconnect.
+		for _, player := range selected.Players {
+			activity := byClient[player]
+			ip := player.Addr()
+			totalBytes += activity.Bytes
+			if ip.Is4() {
+				ipv4Players++
+			} else {
+				ipv6Players++
+			}
 
-		for ip, bytes := range ipMap {
-			uniqueIPs = append(uniqueIPs, ip)
-			totalBytes += bytes
+			first, last := e.clock.ToWall(activity.FirstSeen), e.clock.ToWall(activity.LastSeen)
+			if ps, ok := seen[serverID][ip]; ok {
+				seen[serverID][ip] = playerSeen{first: minTime(ps.first, first), last: maxTime(ps.last, last)}
+				continue
+			}
+			uniqueIPs = append(uniqueIPs, ip.String())
+			seen[serverID][ip] = playerSeen{first: first, last: last}
 		}
 
-		slog.Debug("Server stats", "id", serverID, "port", port, "players", len(uniqueIPs), "totalBytes", totalBytes)
+		roles := make(map[discovery.PortRole]RoleStats, len(sf.roles))
+		for role, ipMap := range sf.roles {
+			rs := RoleStats{Clients: len(ipMap)}
+			for _, bytes := range ipMap {
+				rs.Bytes += bytes
+			}
+			roles[role] = rs
+		}
+
+		slog.Debug("Server stats", "id", serverID, "players", len(selected.Players), "ips", len(uniqueIPs), "clients", len(clients), "strategy", strategy.Name(), "confidence", selected.Confidence, "ipv4", ipv4Players, "ipv6", ipv6Players, "totalBytes", totalBytes, "roles", roles)
 
 		stats = append(stats, ServerPlayerStats{
-			ServerID:      serverID,
-			ActivePlayers: len(uniqueIPs),
-			UniqueIPs:     uniqueIPs,
-			TotalBytes:    totalBytes,
-			SampleWindow:  e.activityThreshold,
-			Timestamp:     time.Now(),
+			ServerID:         serverID,
+			ActivePlayers:    len(selected.Players),
+			IPv4Players:      ipv4Players,
+			IPv6Players:      ipv6Players,
+			UniqueIPs:        uniqueIPs,
+			TotalBytes:       totalBytes,
+			UploadBytes:      sf.uploadBytes,
+			DownloadBytes:    sf.downloadBytes,
+			Roles:            roles,
+			Strategy:         strategy.Name(),
+			Confidence:       selected.Confidence,
+			Strategies:       results,
+			PacketsPerSecond: sf.packetsPerSecond,
+			BytesPerSecond:   sf.bytesPerSecond,
+			SampleWindow:     sf.params.Activity,
+			Timestamp:        now,
 		})
 	}
 
+	e.sessionUpdate = e.sessions.Observe(now, seen)
+
 	return stats
 }
 
-func uint32ToIP(ip uint32) string {
-	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, ip)
-	return net.IP(buf).String()
+// withIntervals fills in the per-interval history of a client for the
+// intervals inside the activity window, newest first.
+func (e *Engine) withIntervals(cf *clientFlows, cutoff uint64) ClientActivity {
+	activity := cf.activity
+	activity.Intervals = make([]Interval, 0, len(e.intervals))
+	for _, iv := range e.intervals {
+		if iv.at < cutoff {
+			break
+		}
+		sample := cf.byTick[iv.tick]
+		activity.Intervals = append(activity.Intervals, Interval{
+			Seconds: iv.seconds,
+			Packets: sample.packets,
+			Bytes:   sample.bytes,
+		})
+	}
+	return activity
 }
 
-func getBootTime() (int64, error) {
-	data, err := os.ReadFile("/proc/stat")
-	if err != nil {
-		return 0, err
+func newClientFlows(addr netip.Addr, port uint16, firstSeen uint64) *clientFlows {
+	return &clientFlows{
+		activity: ClientActivity{Addr: addr, Port: port, FirstSeen: firstSeen},
+		byTick:   make(map[uint64]flowSample),
 	}
+}
 
-	for _, line := range strings.Split(string(data), "\n") {
-		if strings.HasPrefix(line, "btime ") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				btime, err := strconv.ParseInt(fields[1], 10, 64)
-				if err != nil {
-					return 0, err
-				}
-				return btime * 1e9, nil
+func (cf *clientFlows) add(packets, bytes, firstSeen, lastSeen uint64) {
+	cf.activity.Packets += packets
+	cf.activity.Bytes += bytes
+	cf.activity.FirstSeen = min(cf.activity.FirstSeen, firstSeen)
+	cf.activity.LastSeen = max(cf.activity.LastSeen, lastSeen)
+}
+
+func (cf *clientFlows) addSample(sample flowSample) {
+	t := cf.byTick[sample.tick]
+	t.at = max(t.at, sample.at)
+	t.packets += sample.packets
+	t.bytes += sample.bytes
+	cf.byTick[sample.tick] = t
+}
+
+// groupEndpoints turns the source ports of one address into clients. Ports
+// in use at the same time belong to different clients; a port that only
+// appears after another went quiet is taken to be the same client after a
+// reconnect. This is interval partitioning, so the number of clients is the
+// most ports the address had active at once.
+func groupEndpoints(endpoints map[uint16]*clientFlows) []*clientFlows {
+	if len(endpoints) == 1 {
+		for _, cf := range endpoints {
+			return []*clientFlows{cf}
+		}
+	}
+
+	sorted := make([]*clientFlows, 0, len(endpoints))
+	for _, cf := range endpoints {
+		sorted = append(sorted, cf)
+	}
+	slices.SortFunc(sorted, func(a, b *clientFlows) int {
+		return cmp.Compare(a.activity.FirstSeen, b.activity.FirstSeen)
+	})
+
+	var clients []*clientFlows
+	for _, ep := range sorted {
+		// Continue the client that went quiet first, so a reconnect is not
+		// attributed to a client that is still active.
+		var next *clientFlows
+		for _, c := range clients {
+			if c.activity.LastSeen <= ep.activity.FirstSeen+reconnectOverlap && (next == nil || c.activity.LastSeen < next.activity.LastSeen) {
+				next = c
 			}
 		}
+		if next == nil {
+			next = newClientFlows(ep.activity.Addr, ep.activity.Port, ep.activity.FirstSeen)
+			clients = append(clients, next)
+		}
+		next.activity.Port = ep.activity.Port
+		next.add(ep.activity.Packets, ep.activity.Bytes, ep.activity.FirstSeen, ep.activity.LastSeen)
+		next.activity.DownloadPackets += ep.activity.DownloadPackets
+		next.activity.DownloadBytes += ep.activity.DownloadBytes
+		for tick, sample := range ep.byTick {
+			sample.tick = tick
+			next.addSample(sample)
+		}
 	}
+	return clients
+}
 
-	return 0, os.ErrNotExist
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,69 @@
+package estimator
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// Profile is a named set of estimator parameters for one kind of game. A server uses
+// the profile it names, or else, also when the named profile does not exist,
+// the first profile, by name, with an image pattern matching the server's
+// image.
+type Profile struct {
+	Name   string
+	Images []string
+	Params Params
+}
+
+// Profiles selects estimator parameters per server.
+type Profiles struct {
+	byName map[string]Profile
+	names  []string
+}
+
+func NewProfiles(profiles []Profile) *Profiles {
+	p := &Profiles{byName: make(map[string]Profile, len(profiles))}
+	for _, profile := range profiles {
+		p.byName[profile.Name] = profile
+		p.names = append(p.names, profile.Name)
+	}
+	sort.Strings(p.names)
+	return p
+}
+
+// Has reports whether a profile with the given name exists.
+func (p *Profiles) Has(name string) bool {
+	_, ok := p.byName[name]
+	return ok
+}
+
+// Select returns the profile for a server with the given profile name and
+// image. An unknown name falls back to the image. Image patterns use
+// path.Match syntax, e.g. "itzg/minecraft-server*".
+func (p *Profiles) Select(name, image string) (Profile, bool) {
+	if profile, ok := p.byName[name]; ok && name != "" {
+		return profile, true
+	}
+	if image == "" {
+		return Profile{}, false
+	}
+
+	for _, n := range p.names {
+		for _, pattern := range p.byName[n].Images {
+			if ok, _ := path.Match(pattern, image); ok {
+				return p.byName[n], true
+			}
+		}
+	}
+	return Profile{}, false
+}
+
+// MaxActivity returns the longest activity window of any profile.
+func (p *Profiles) MaxActivity() time.Duration {
+	var longest time.Duration
+	for _, profile := range p.byName {
+		longest = max(longest, profile.Params.Activity)
+	}
+	return longest
+}
@@ -0,0 +1,39 @@
+package estimator
+
+import "testing"
+
+func TestProfilesSelect(t *testing.T) {
+	profiles := NewProfiles([]Profile{
+		{Name: "minecraft", Images: []string{"itzg/minecraft-server*"}},
+		{Name: "rust", Images: []string{"didstopia/rust-server*", "*/rust:*"}},
+		{Name: "vanilla", Images: []string{"itzg/minecraft-server:*"}},
+	})
+
+	tests := []struct {
+		name    string
+		profile string
+		image   string
+		want    string
+	}{
+		{name: "named profile wins over image", profile: "rust", image: "itzg/minecraft-server:latest", want: "rust"},
+		{name: "image match", image: "didstopia/rust-server:latest", want: "rust"},
+		{name: "first match by name", image: "itzg/minecraft-server:java21", want: "minecraft"},
+		{name: "unknown name falls back to image", profile: "rsut", image: "example/rust:1", want: "rust"},
+		{name: "unknown name without match", profile: "rsut", image: "nginx:latest"},
+		{name: "glob does not cross slash", image: "registry/example/rust:1"},
+		{name: "nothing to match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, ok := profiles.Select(tt.profile, tt.image)
+			if ok != (tt.want != "") || profile.Name != tt.want {
+				t.Errorf("Select(%q, %q) = %q, %v, want %q", tt.profile, tt.image, profile.Name, ok, tt.want)
+			}
+		})
+	}
+
+	if !profiles.Has("rust") || profiles.Has("rsut") {
+		t.Error("Has reports the wrong profiles")
+	}
+}
@@ -0,0 +1,116 @@
+package estimator
+
+import (
+	"net/netip"
+	"time"
+)
+
+// Session is one player's continuous presence on a server. Left is zero
+// while the session is active.
+type Session struct {
+	ServerID string
+	ClientIP string
+	Joined   time.Time
+	LastSeen time.Time
+	Left     time.Time
+}
+
+func (s Session) Duration() time.Duration {
+	end := s.Left
+	if end.IsZero() {
+		end = s.LastSeen
+	}
+	return end.Sub(s.Joined)
+}
+
+// SessionUpdate is the result of one SessionTracker.Observe call.
+type SessionUpdate struct {
+	Joined []Session
+	Left   []Session
+	Active []Session
+}
+
+type sessionKey struct {
+	serverID string
+	addr     netip.Addr
+}
+
+// playerSeen holds the first and last packet time of a player across all of
+// its game flows on a server.
+type playerSeen struct {
+	first time.Time
+	last  time.Time
+}
+
+// SessionTracker turns the per-tick player sets of the estimator into
+// sessions. A session ends once the player drops out of the set, which
+// happens when its flows have been idle past the activity threshold; the
+// leave time is the last packet seen.
+type SessionTracker struct {
+	active   map[sessionKey]*Session
+	lastLeft map[sessionKey]time.Time
+	lastTick time.Time
+}
+
+// leftRetention bounds how long the end of a finished session is remembered
+// to detect a rejoin on a flow entry that is still in the map.
+const leftRetention = 24 * time.Hour
+
+func NewSessionTracker() *SessionTracker {
+	return &SessionTracker{
+		active:   make(map[sessionKey]*Session),
+		lastLeft: make(map[sessionKey]time.Time),
+	}
+}
+
+func (t *SessionTracker) Observe(now time.Time, players map[string]map[netip.Addr]playerSeen) SessionUpdate {
+	var update SessionUpdate
+
+	for serverID, ipMap := range players {
+		for addr, seen := range ipMap {
+			key := sessionKey{serverID: serverID, addr: addr}
+			s, ok := t.active[key]
+			if !ok {
+				joined := seen.first
+				// A rejoin on a flow entry that outlived the previous session
+				// still carries the old first-seen time. The player came back
+				// some time after the previous tick.
+				if left, ok := t.lastLeft[key]; ok && !joined.After(left) && !t.lastTick.IsZero() {
+					joined = t.lastTick
+				}
+				delete(t.lastLeft, key)
+
+				s = &Session{ServerID: serverID, ClientIP: addr.String(), Joined: joined}
+				t.active[key] = s
+				s.LastSeen = seen.last
+				update.Joined = append(update.Joined, *s)
+				continue
+			}
+			s.LastSeen = seen.last
+		}
+	}
+
+	for key, s := range t.active {
+		if _, ok := players[key.serverID][key.addr]; ok {
+			continue
+		}
+		s.Left = s.LastSeen
+		update.Left = append(update.Left, *s)
+		t.lastLeft[key] = s.LastSeen
+		delete(t.active, key)
+	}
+
+	for key, left := range t.lastLeft {
+		if now.Sub(left) > leftRetention {
+			delete(t.lastLeft, key)
+		}
+	}
+
+	update.Active = make([]Session, 0, len(t.active))
+	for _, s := range t.active {
+		update.Active = append(update.Active, *s)
+	}
+
+	t.lastTick = now
+	return update
+}
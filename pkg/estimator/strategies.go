@@ -0,0 +1,90 @@
+package estimator
+
+import (
+	"math"
+	"net/netip"
+)
+
+// ThresholdStrategy counts clients that sent at least MinPackets and MinBytes
+// within the activity window.
+type ThresholdStrategy struct{}
+
+func (ThresholdStrategy) Name() string { return "threshold" }
+
+func (ThresholdStrategy) Estimate(clients []ClientActivity, params Params) Estimate {
+	return decide(clients, func(c ClientActivity) float64 {
+		return min(
+			ratio(float64(c.Packets), float64(params.MinPackets)),
+			ratio(float64(c.Bytes), float64(params.MinBytes)),
+		)
+	})
+}
+
+// RateStrategy counts clients that kept up at least MinPacketRate packets per
+// second in each of the last RateIntervals metrics intervals. It filters out
+// short bursts such as server list queries, at the cost of reporting new
+// players only after RateIntervals intervals.
+type RateStrategy struct{}
+
+func (RateStrategy) Name() string { return "rate" }
+
+func (RateStrategy) Estimate(clients []ClientActivity, params Params) Estimate {
+	n := max(params.RateIntervals, 1)
+	return decide(clients, func(c ClientActivity) float64 {
+		if len(c.Intervals) == 0 {
+			return 0
+		}
+		margin := math.Inf(1)
+		for _, iv := range c.Intervals[:min(n, len(c.Intervals))] {
+			margin = min(margin, ratio(float64(iv.Packets)/iv.Seconds, params.MinPacketRate))
+		}
+		// A client that keeps up the rate but has not been seen for
+		// RateIntervals yet is undecided rather than clearly not a player.
+		if len(c.Intervals) < n {
+			margin = min(margin, math.Nextafter(1, 0))
+		}
+		return margin
+	})
+}
+
+// PacketSizeStrategy counts clients that sent at least MinPackets within the
+// activity window with an average packet size between MinPacketSize and
+// MaxPacketSize. Game clients send a steady stream of small packets; bulk
+// transfers and pings fall outside the band.
+type PacketSizeStrategy struct{}
+
+func (PacketSizeStrategy) Name() string { return "packet_size" }
+
+func (PacketSizeStrategy) Estimate(clients []ClientActivity, params Params) Estimate {
+	return decide(clients, func(c ClientActivity) float64 {
+		if c.Packets == 0 {
+			return 0
+		}
+		avg := float64(c.Bytes) / float64(c.Packets)
+		sizeMargin := ratio(avg, float64(params.MinPacketSize))
+		if params.MaxPacketSize > 0 {
+			sizeMargin = min(sizeMargin, float64(params.MaxPacketSize)/avg)
+		}
+		return min(ratio(float64(c.Packets), float64(params.MinPackets)), sizeMargin)
+	})
+}
+
+// decide counts the clients whose margin is at least 1 and averages the
+// certainty of every decision into the confidence.
+func decide(clients []ClientActivity, margin func(ClientActivity) float64) Estimate {
+	if len(clients) == 0 {
+		return Estimate{Confidence: 1}
+	}
+
+	var est Estimate
+	var sum float64
+	for _, c := range clients {
+		m := margin(c)
+		if m >= 1 {
+			est.Players = append(est.Players, netip.AddrPortFrom(c.Addr, c.Port))
+		}
+		sum += certainty(m)
+	}
+	est.Confidence = sum / float64(len(clients))
+	return est
+}
@@ -0,0 +1,164 @@
+package estimator
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+)
+
+const epsilon = 1e-9
+
+func client(packets, bytes uint64, intervals ...Interval) ClientActivity {
+	return ClientActivity{
+		Addr:      netip.MustParseAddr("198.51.100.7"),
+		Packets:   packets,
+		Bytes:     bytes,
+		Intervals: intervals,
+	}
+}
+
+// second is one metrics interval of a second with the given packet count.
+func second(packets uint64) Interval {
+	return Interval{Seconds: 1, Packets: packets, Bytes: packets * 100}
+}
+
+func TestCertainty(t *testing.T) {
+	tests := []struct {
+		margin float64
+		want   float64
+	}{
+		{margin: 0, want: 1},
+		{margin: 0.25, want: 1},
+		{margin: 0.5, want: 1},
+		{margin: 0.75, want: 0.75},
+		{margin: math.Nextafter(1, 0), want: 0.5},
+		{margin: 1, want: 0.5},
+		{margin: 1.5, want: 0.75},
+		{margin: 2, want: 1},
+		{margin: 10, want: 1},
+		{margin: math.Inf(1), want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := certainty(tt.margin); math.Abs(got-tt.want) > epsilon {
+			t.Errorf("certainty(%v) = %v, want %v", tt.margin, got, tt.want)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	margins := func(ms ...float64) func(ClientActivity) float64 {
+		return func(c ClientActivity) float64 { return ms[c.Port] }
+	}
+	clients := func(n int) []ClientActivity {
+		cs := make([]ClientActivity, n)
+		for i := range cs {
+			cs[i] = ClientActivity{Addr: netip.MustParseAddr("198.51.100.7"), Port: uint16(i)}
+		}
+		return cs
+	}
+
+	tests := []struct {
+		name           string
+		clients        []ClientActivity
+		margin         func(ClientActivity) float64
+		wantPlayers    int
+		wantConfidence float64
+	}{
+		{name: "no clients", margin: margins(), wantConfidence: 1},
+		{name: "clear-cut", clients: clients(2), margin: margins(3, 0.1), wantPlayers: 1, wantConfidence: 1},
+		{name: "at the threshold counts", clients: clients(1), margin: margins(1), wantPlayers: 1, wantConfidence: 0.5},
+		{name: "averaged", clients: clients(2), margin: margins(1.5, 0.75), wantPlayers: 1, wantConfidence: 0.75},
+		{name: "mixed", clients: clients(4), margin: margins(2, 1, 0.5, 0.75), wantPlayers: 2, wantConfidence: (1 + 0.5 + 1 + 0.75) / 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est := decide(tt.clients, tt.margin)
+			if len(est.Players) != tt.wantPlayers {
+				t.Errorf("players = %v, want %d", est.Players, tt.wantPlayers)
+			}
+			if math.Abs(est.Confidence-tt.wantConfidence) > epsilon {
+				t.Errorf("confidence = %v, want %v", est.Confidence, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+type strategyCase struct {
+	name           string
+	client         ClientActivity
+	wantPlayer     bool
+	wantConfidence float64
+}
+
+func runStrategy(t *testing.T, e Estimator, params Params, tests []strategyCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est := e.Estimate([]ClientActivity{tt.client}, params)
+			if got := len(est.Players) == 1; got != tt.wantPlayer {
+				t.Errorf("player = %v, want %v", got, tt.wantPlayer)
+			}
+			if math.Abs(est.Confidence-tt.wantConfidence) > epsilon {
+				t.Errorf("confidence = %v, want %v", est.Confidence, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestThresholdStrategy(t *testing.T) {
+	params := Params{MinPackets: 100, MinBytes: 10000}
+
+	runStrategy(t, ThresholdStrategy{}, params, []strategyCase{
+		{name: "exactly at both thresholds", client: client(100, 10000), wantPlayer: true, wantConfidence: 0.5},
+		{name: "one packet short", client: client(99, 10000), wantConfidence: 0.51},
+		{name: "one byte short", client: client(100, 9999), wantConfidence: 0.5001},
+		{name: "well above", client: client(200, 20000), wantPlayer: true, wantConfidence: 1},
+		{name: "packets limit the margin", client: client(150, 50000), wantPlayer: true, wantConfidence: 0.75},
+		{name: "bytes limit the margin", client: client(1000, 15000), wantPlayer: true, wantConfidence: 0.75},
+		{name: "well below", client: client(50, 50000), wantConfidence: 1},
+		{name: "nothing sent", client: client(0, 0), wantConfidence: 1},
+	})
+
+	runStrategy(t, ThresholdStrategy{}, Params{MinPackets: 100}, []strategyCase{
+		{name: "zero byte threshold is always met", client: client(100, 0), wantPlayer: true, wantConfidence: 0.5},
+	})
+}
+
+func TestRateStrategy(t *testing.T) {
+	params := Params{MinPacketRate: 10, RateIntervals: 3}
+
+	runStrategy(t, RateStrategy{}, params, []strategyCase{
+		{name: "at the rate in every interval", client: client(30, 3000, second(10), second(10), second(10)), wantPlayer: true, wantConfidence: 0.5},
+		{name: "slowest interval decides", client: client(0, 0, second(40), second(15), second(30)), wantPlayer: true, wantConfidence: 0.75},
+		{name: "one interval below", client: client(0, 0, second(20), second(9), second(20)), wantConfidence: 0.6},
+		{name: "burst then idle", client: client(0, 0, second(0), second(500), second(0)), wantConfidence: 1},
+		{name: "older intervals are ignored", client: client(0, 0, second(20), second(20), second(20), second(0)), wantPlayer: true, wantConfidence: 1},
+		{name: "not seen long enough", client: client(0, 0, second(50), second(50)), wantConfidence: 0.5},
+		{name: "no intervals", client: client(100, 10000), wantConfidence: 1},
+		{name: "longer interval", client: client(0, 0, Interval{Seconds: 2, Packets: 30}, second(15), second(15)), wantPlayer: true, wantConfidence: 0.75},
+	})
+
+	runStrategy(t, RateStrategy{}, Params{MinPacketRate: 10}, []strategyCase{
+		{name: "zero intervals means one", client: client(0, 0, second(10)), wantPlayer: true, wantConfidence: 0.5},
+	})
+}
+
+func TestPacketSizeStrategy(t *testing.T) {
+	params := Params{MinPackets: 100, MinPacketSize: 40, MaxPacketSize: 400}
+
+	runStrategy(t, PacketSizeStrategy{}, params, []strategyCase{
+		{name: "in the band", client: client(200, 200*100), wantPlayer: true, wantConfidence: 1},
+		{name: "at the lower bound", client: client(200, 200*40), wantPlayer: true, wantConfidence: 0.5},
+		{name: "at the upper bound", client: client(200, 200*400), wantPlayer: true, wantConfidence: 0.5},
+		{name: "too small", client: client(200, 200*30), wantConfidence: 0.75},
+		{name: "bulk transfer", client: client(200, 200*1200), wantConfidence: 1},
+		{name: "too few packets", client: client(75, 75*100), wantConfidence: 0.75},
+		{name: "nothing sent", client: client(0, 0), wantConfidence: 1},
+	})
+
+	runStrategy(t, PacketSizeStrategy{}, Params{MinPackets: 100, MinPacketSize: 40}, []strategyCase{
+		{name: "no upper bound", client: client(200, 200*1400), wantPlayer: true, wantConfidence: 1},
+	})
+}
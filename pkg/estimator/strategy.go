@@ -0,0 +1,131 @@
+package estimator
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Estimator is a rule deciding which clients of a server are active players.
+// Implementations must be stateless; everything they need is in the client
+// activity and the server's parameters.
+type Estimator interface {
+	Name() string
+	Estimate(clients []ClientActivity, params Params) Estimate
+}
+
+// Params configure how the players of a server are estimated. Each strategy
+// reads the fields it needs.
+type Params struct {
+	Strategy string
+	// Activity is the window traffic is considered in. Clients idle for
+	// longer are not players under any strategy.
+	Activity   time.Duration
+	MinPackets uint64
+	MinBytes   uint64
+	// MinPacketRate is the packets per second a client must sustain in each
+	// of the last RateIntervals metrics intervals.
+	MinPacketRate float64
+	RateIntervals int
+	// MinPacketSize and MaxPacketSize bound the average packet size of
+	// game traffic, in bytes.
+	MinPacketSize uint64
+	MaxPacketSize uint64
+	// Bidirectional only considers clients the server replied to within
+	// the activity window. It needs egress monitoring.
+	Bidirectional bool
+}
+
+// ClientActivity is the game traffic of one client on one server, summed
+// over the server's game ports. On servers that key flows by source port,
+// clients sharing an address are told apart and Port is the source port the
+// client used last; otherwise it is zero and a client is an address.
+type ClientActivity struct {
+	Addr netip.Addr
+	Port uint16
+	// Packets and Bytes were sent within the activity window, and
+	// DownloadPackets and DownloadBytes received from the server when egress
+	// is monitored.
+	Packets         uint64
+	Bytes           uint64
+	DownloadPackets uint64
+	DownloadBytes   uint64
+	FirstSeen       uint64
+	LastSeen        uint64
+	// Intervals is the traffic in the most recent metrics intervals inside
+	// the activity window, newest first. Intervals without traffic are zero.
+	Intervals []Interval
+}
+
+type Interval struct {
+	Seconds float64
+	Packets uint64
+	Bytes   uint64
+}
+
+// Estimate is the result of one strategy for one server. Confidence is
+// between 0 and 1 and falls as more clients sit close to the strategy's
+// decision boundary.
+type Estimate struct {
+	Players    []netip.AddrPort
+	Confidence float64
+}
+
+// DefaultStrategy is used by servers that don't select one.
+const DefaultStrategy = "threshold"
+
+var registry = map[string]Estimator{}
+
+func init() {
+	Register(ThresholdStrategy{})
+	Register(RateStrategy{})
+	Register(PacketSizeStrategy{})
+}
+
+// Register adds a strategy to the registry, replacing one of the same name.
+func Register(e Estimator) {
+	registry[e.Name()] = e
+}
+
+// Lookup returns the registered strategy called name.
+func Lookup(name string) (Estimator, error) {
+	e, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown estimator strategy %q, expected one of %v", name, Strategies())
+	}
+	return e, nil
+}
+
+// Strategies returns the names of all registered strategies, sorted.
+func Strategies() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// certainty turns the margin of one decision, the measured value divided by
+// the threshold, into how clear-cut it is: 1 at half or twice the threshold
+// and beyond, 0.5 right at it.
+func certainty(margin float64) float64 {
+	switch {
+	case margin >= 2 || margin <= 0.5:
+		return 1
+	case margin >= 1:
+		return 0.5 + 0.5*(margin-1)
+	default:
+		return 0.5 + (1 - margin)
+	}
+}
+
+// ratio returns value/threshold, treating a zero threshold as always met by
+// a wide margin.
+func ratio(value, threshold float64) float64 {
+	if threshold <= 0 {
+		return 2
+	}
+	return value / threshold
+}
@@ -1,12 +1,48 @@
 package estimator
 
-import "time"
+import (
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
 
 type ServerPlayerStats struct {
 	ServerID      string
+	Labels        map[string]string
 	ActivePlayers int
+	// ActualPlayers is the player count the server itself reported, set
+	// when Calibrated.
+	ActualPlayers int
+	Calibrated    bool
+	IPv4Players   int
+	IPv6Players   int
 	UniqueIPs     []string
 	TotalBytes    uint64
-	SampleWindow  time.Duration
-	Timestamp     time.Time
+	// UploadBytes were sent by clients and DownloadBytes by the server on
+	// all of its ports within the sample window. DownloadBytes needs egress
+	// monitoring.
+	UploadBytes      uint64
+	DownloadBytes    uint64
+	Roles            map[discovery.PortRole]RoleStats
+	Strategy         string
+	Confidence       float64
+	Strategies       map[string]StrategyResult
+	PacketsPerSecond float64
+	BytesPerSecond   float64
+	SampleWindow     time.Duration
+	Timestamp        time.Time
+}
+
+// RoleStats counts the clients seen on a server's non-game ports, such as
+// query or voice. Player thresholds are not applied to them.
+type RoleStats struct {
+	Clients int
+	Bytes   uint64
+}
+
+// StrategyResult is the estimate of one strategy, reported for every
+// registered strategy so they can be compared.
+type StrategyResult struct {
+	Players    int
+	Confidence float64
 }
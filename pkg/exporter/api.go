@@ -6,28 +6,126 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rxtx-hosting/flowlens/pkg/calibration"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
 	"github.com/rxtx-hosting/flowlens/pkg/estimator"
 )
 
+// maxRecentSessions bounds the finished sessions kept per server.
+const maxRecentSessions = 100
+
 type APIServer struct {
-	apiKey string
-	cache  map[string]estimator.ServerPlayerStats
-	mu     sync.RWMutex
+	apiKey   string
+	cache    map[string]estimator.ServerPlayerStats
+	pressure ebpf.MapPressure
+	calib    *calibration.Report
+	sessions map[string]*serverSessions
+	mu       sync.RWMutex
+}
+
+type serverSessions struct {
+	active []estimator.Session
+	recent []estimator.Session
+	joins  uint64
+	leaves uint64
 }
 
 type metricsResponse struct {
-	ServerID            string   `json:"server_id"`
-	ActivePlayers       int      `json:"active_players"`
-	UniqueIPs           []string `json:"unique_ips,omitempty"`
-	SampleWindowSeconds int      `json:"sample_window_seconds"`
-	TotalBytes          uint64   `json:"total_bytes"`
-	Timestamp           string   `json:"timestamp"`
+	ServerID            string                      `json:"server_id"`
+	Labels              map[string]string           `json:"labels,omitempty"`
+	ActivePlayers       int                         `json:"active_players"`
+	ActualPlayers       *int                        `json:"actual_players,omitempty"`
+	IPv4Players         int                         `json:"ipv4_players"`
+	IPv6Players         int                         `json:"ipv6_players"`
+	UniqueIPCount       int                         `json:"unique_ip_count"`
+	UniqueIPs           []string                    `json:"unique_ips,omitempty"`
+	SampleWindowSeconds int                         `json:"sample_window_seconds"`
+	TotalBytes          uint64                      `json:"total_bytes"`
+	UploadBytes         uint64                      `json:"upload_bytes"`
+	DownloadBytes       uint64                      `json:"download_bytes"`
+	PacketsPerSecond    float64                     `json:"packets_per_second"`
+	BytesPerSecond      float64                     `json:"bytes_per_second"`
+	Roles               map[string]roleResponse     `json:"roles,omitempty"`
+	Strategy            string                      `json:"strategy"`
+	Confidence          float64                     `json:"confidence"`
+	Strategies          map[string]strategyResponse `json:"strategies,omitempty"`
+	Timestamp           string                      `json:"timestamp"`
+}
+
+type roleResponse struct {
+	Clients int    `json:"clients"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+type strategyResponse struct {
+	Players    int     `json:"players"`
+	Confidence float64 `json:"confidence"`
+}
+
+type flowMapResponse struct {
+	Entries            int     `json:"entries"`
+	Capacity           uint32  `json:"capacity"`
+	Utilization        float64 `json:"utilization"`
+	Inserts            uint64  `json:"inserts"`
+	Deleted            uint64  `json:"deleted"`
+	EstimatedEvictions uint64  `json:"estimated_evictions"`
+}
+
+type sessionResponse struct {
+	ClientIP        string  `json:"client_ip"`
+	Joined          string  `json:"joined"`
+	LastSeen        string  `json:"last_seen"`
+	Left            string  `json:"left,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type errorStatsResponse struct {
+	Samples       int     `json:"samples"`
+	QueryErrors   int     `json:"query_errors"`
+	MeanError     float64 `json:"mean_error"`
+	MeanAbsError  float64 `json:"mean_abs_error"`
+	RMSE          float64 `json:"rmse"`
+	LastActual    int     `json:"last_actual"`
+	LastEstimated int     `json:"last_estimated"`
+	LastSample    string  `json:"last_sample,omitempty"`
+}
+
+type calibrationServerResponse struct {
+	ServerID string             `json:"server_id"`
+	Game     string             `json:"game"`
+	Protocol string             `json:"protocol"`
+	Errors   errorStatsResponse `json:"errors"`
+}
+
+type thresholdsResponse struct {
+	MinPacketsThreshold uint64  `json:"min_packets_threshold"`
+	MinBytesThreshold   uint64  `json:"min_bytes_threshold"`
+	MinPacketRate       float64 `json:"min_packet_rate"`
+}
+
+type calibrationGameResponse struct {
+	Game      string              `json:"game"`
+	Errors    errorStatsResponse  `json:"errors"`
+	Current   thresholdsResponse  `json:"current"`
+	Samples   int                 `json:"suggestion_samples"`
+	Suggested *thresholdsResponse `json:"suggested,omitempty"`
+}
+
+type sessionsResponse struct {
+	ServerID              string            `json:"server_id"`
+	Active                []sessionResponse `json:"active"`
+	Recent                []sessionResponse `json:"recent"`
+	Joins                 uint64            `json:"joins"`
+	Leaves                uint64            `json:"leaves"`
+	AverageSessionSeconds float64           `json:"average_session_seconds"`
 }
 
 func NewAPIServer(apiKey string) *APIServer {
 	return &APIServer{
-		apiKey: apiKey,
-		cache:  make(map[string]estimator.ServerPlayerStats),
+		apiKey:   apiKey,
+		cache:    make(map[string]estimator.ServerPlayerStats),
+		sessions: make(map[string]*serverSessions),
 	}
 }
 
@@ -41,15 +139,82 @@ func (a *APIServer) UpdateStats(stats []estimator.ServerPlayerStats) {
 	}
 }
 
+// UpdateServers keeps session history for the discovered servers only.
+// Servers without players stay, so idle servers keep their history.
+func (a *APIServer) UpdateServers(servers []discovery.ServerMetadata) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sessions := make(map[string]*serverSessions, len(servers))
+	for _, srv := range servers {
+		ss := a.sessions[srv.ServerID]
+		if ss == nil {
+			ss = &serverSessions{}
+		}
+		sessions[srv.ServerID] = ss
+	}
+	a.sessions = sessions
+}
+
+func (a *APIServer) UpdateSessions(update estimator.SessionUpdate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Sessions of servers that discovery dropped are ignored.
+	for _, ss := range a.sessions {
+		ss.active = ss.active[:0]
+	}
+	for _, s := range update.Active {
+		if ss := a.sessions[s.ServerID]; ss != nil {
+			ss.active = append(ss.active, s)
+		}
+	}
+	for _, s := range update.Joined {
+		if ss := a.sessions[s.ServerID]; ss != nil {
+			ss.joins++
+		}
+	}
+	for _, s := range update.Left {
+		ss := a.sessions[s.ServerID]
+		if ss == nil {
+			continue
+		}
+		ss.leaves++
+		ss.recent = append(ss.recent, s)
+		if len(ss.recent) > maxRecentSessions {
+			ss.recent = ss.recent[len(ss.recent)-maxRecentSessions:]
+		}
+	}
+}
+
+func (a *APIServer) UpdateMapPressure(pressure ebpf.MapPressure) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pressure = pressure
+}
+
+func (a *APIServer) UpdateCalibration(report calibration.Report) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.calib = &report
+}
+
 func (a *APIServer) StartServer(addr string) error {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
+	// Server IDs such as Agones namespace/name contain a slash, sent as %2F.
+	r.UseRawPath = true
 	r.Use(gin.Recovery())
 
 	r.Use(a.authMiddleware())
 
 	r.GET("/metrics/servers", a.handleGetAllServers)
 	r.GET("/metrics/servers/:id", a.handleGetServer)
+	r.GET("/metrics/servers/:id/sessions", a.handleGetSessions)
+	r.GET("/metrics/flowmap", a.handleGetFlowMap)
+	r.GET("/metrics/calibration", a.handleGetCalibration)
 
 	return r.Run(addr)
 }
@@ -93,13 +258,172 @@ func (a *APIServer) handleGetServer(c *gin.Context) {
 	c.JSON(http.StatusOK, a.statToResponse(stat))
 }
 
+func (a *APIServer) handleGetSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ss, exists := a.sessions[id]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+		return
+	}
+
+	response := sessionsResponse{
+		ServerID: id,
+		Active:   make([]sessionResponse, 0, len(ss.active)),
+		Recent:   make([]sessionResponse, 0, len(ss.recent)),
+		Joins:    ss.joins,
+		Leaves:   ss.leaves,
+	}
+
+	for _, s := range ss.active {
+		response.Active = append(response.Active, sessionToResponse(s))
+	}
+
+	var total time.Duration
+	for _, s := range ss.recent {
+		response.Recent = append(response.Recent, sessionToResponse(s))
+		total += s.Duration()
+	}
+	if len(ss.recent) > 0 {
+		response.AverageSessionSeconds = total.Seconds() / float64(len(ss.recent))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func sessionToResponse(s estimator.Session) sessionResponse {
+	r := sessionResponse{
+		ClientIP:        s.ClientIP,
+		Joined:          s.Joined.Format(time.RFC3339),
+		LastSeen:        s.LastSeen.Format(time.RFC3339),
+		DurationSeconds: s.Duration().Seconds(),
+	}
+	if !s.Left.IsZero() {
+		r.Left = s.Left.Format(time.RFC3339)
+	}
+	return r
+}
+
+func (a *APIServer) handleGetFlowMap(c *gin.Context) {
+	a.mu.RLock()
+	p := a.pressure
+	a.mu.RUnlock()
+
+	c.JSON(http.StatusOK, flowMapResponse{
+		Entries:            p.Entries,
+		Capacity:           p.Capacity,
+		Utilization:        p.Utilization,
+		Inserts:            p.Inserts,
+		Deleted:            p.Deleted,
+		EstimatedEvictions: p.EstimatedEvictions,
+	})
+}
+
 func (a *APIServer) statToResponse(stat estimator.ServerPlayerStats) metricsResponse {
+	var roles map[string]roleResponse
+	if len(stat.Roles) > 0 {
+		roles = make(map[string]roleResponse, len(stat.Roles))
+		for role, rs := range stat.Roles {
+			roles[string(role)] = roleResponse{Clients: rs.Clients, Bytes: rs.Bytes}
+		}
+	}
+
+	var strategies map[string]strategyResponse
+	if len(stat.Strategies) > 0 {
+		strategies = make(map[string]strategyResponse, len(stat.Strategies))
+		for name, sr := range stat.Strategies {
+			strategies[name] = strategyResponse{Players: sr.Players, Confidence: sr.Confidence}
+		}
+	}
+
+	var actual *int
+	if stat.Calibrated {
+		actual = &stat.ActualPlayers
+	}
+
 	return metricsResponse{
 		ServerID:            stat.ServerID,
+		Labels:              stat.Labels,
 		ActivePlayers:       stat.ActivePlayers,
+		ActualPlayers:       actual,
+		IPv4Players:         stat.IPv4Players,
+		IPv6Players:         stat.IPv6Players,
+		UniqueIPCount:       len(stat.UniqueIPs),
 		UniqueIPs:           stat.UniqueIPs,
 		SampleWindowSeconds: int(stat.SampleWindow.Seconds()),
 		TotalBytes:          stat.TotalBytes,
+		UploadBytes:         stat.UploadBytes,
+		DownloadBytes:       stat.DownloadBytes,
+		PacketsPerSecond:    stat.PacketsPerSecond,
+		BytesPerSecond:      stat.BytesPerSecond,
+		Roles:               roles,
+		Strategy:            stat.Strategy,
+		Confidence:          stat.Confidence,
+		Strategies:          strategies,
 		Timestamp:           stat.Timestamp.Format(time.RFC3339),
 	}
 }
+
+func (a *APIServer) handleGetCalibration(c *gin.Context) {
+	a.mu.RLock()
+	report := a.calib
+	a.mu.RUnlock()
+
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "calibration not enabled"})
+		return
+	}
+
+	servers := make([]calibrationServerResponse, 0, len(report.Servers))
+	for id, sr := range report.Servers {
+		servers = append(servers, calibrationServerResponse{
+			ServerID: id,
+			Game:     sr.Game,
+			Protocol: sr.Protocol,
+			Errors:   errorStatsToResponse(sr.Errors),
+		})
+	}
+
+	games := make([]calibrationGameResponse, 0, len(report.Games))
+	for name, gr := range report.Games {
+		game := calibrationGameResponse{
+			Game:    name,
+			Errors:  errorStatsToResponse(gr.Errors),
+			Samples: gr.Samples,
+			Current: thresholdsResponse{
+				MinPacketsThreshold: gr.Current.MinPackets,
+				MinBytesThreshold:   gr.Current.MinBytes,
+				MinPacketRate:       gr.Current.MinPacketRate,
+			},
+		}
+		if gr.Suggested != nil {
+			game.Suggested = &thresholdsResponse{
+				MinPacketsThreshold: gr.Suggested.MinPackets,
+				MinBytesThreshold:   gr.Suggested.MinBytes,
+				MinPacketRate:       gr.Suggested.MinPacketRate,
+			}
+		}
+		games = append(games, game)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"servers": servers, "games": games})
+}
+
+func errorStatsToResponse(s calibration.ErrorStats) errorStatsResponse {
+	r := errorStatsResponse{
+		Samples:       s.Samples,
+		QueryErrors:   s.QueryErrors,
+		MeanError:     s.MeanError,
+		MeanAbsError:  s.MeanAbsError,
+		RMSE:          s.RMSE,
+		LastActual:    s.LastActual,
+		LastEstimated: s.LastEstimated,
+	}
+	if !s.LastSample.IsZero() {
+		r.LastSample = s.LastSample.Format(time.RFC3339)
+	}
+	return r
+}
@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+)
+
+func TestAPISessionsOutliveLastPlayer(t *testing.T) {
+	a := NewAPIServer("")
+	servers := []discovery.ServerMetadata{{ServerID: "srv"}}
+	a.UpdateServers(servers)
+
+	joined := time.Now()
+	session := estimator.Session{ServerID: "srv", ClientIP: "192.0.2.1", Joined: joined, LastSeen: joined}
+	a.UpdateSessions(estimator.SessionUpdate{Joined: []estimator.Session{session}, Active: []estimator.Session{session}})
+	a.UpdateStats([]estimator.ServerPlayerStats{{ServerID: "srv", ActivePlayers: 1}})
+
+	// The last player goes idle and the server drops out of the stats.
+	session.LastSeen = joined.Add(time.Minute)
+	session.Left = session.LastSeen
+	a.UpdateSessions(estimator.SessionUpdate{Left: []estimator.Session{session}})
+	a.UpdateStats(nil)
+	a.UpdateServers(servers)
+
+	ss := a.sessions["srv"]
+	if ss == nil {
+		t.Fatal("sessions of srv dropped while it is still discovered")
+	}
+	if ss.joins != 1 || ss.leaves != 1 || len(ss.recent) != 1 || len(ss.active) != 0 {
+		t.Errorf("joins, leaves, recent, active = %d, %d, %d, %d, want 1, 1, 1, 0", ss.joins, ss.leaves, len(ss.recent), len(ss.active))
+	}
+
+	// Discovery drops the server, late leaves do not bring it back.
+	a.UpdateServers(nil)
+	a.UpdateSessions(estimator.SessionUpdate{Left: []estimator.Session{session}})
+	if _, ok := a.sessions["srv"]; ok {
+		t.Error("sessions of srv kept after discovery dropped it")
+	}
+}
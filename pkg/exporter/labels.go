@@ -0,0 +1,140 @@
+package exporter
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// overflowValue replaces label values once a label has reached its
+	// distinct value limit.
+	overflowValue  = "other"
+	maxValueLength = 128
+)
+
+// reservedLabels are used by the exporter's own metrics.
+var reservedLabels = map[string]bool{
+	"server_id": true,
+	"family":    true,
+	"role":      true,
+	"reason":    true,
+	"strategy":  true,
+}
+
+// extraLabel maps a server label onto a Prometheus label.
+type extraLabel struct {
+	key  string
+	name string
+}
+
+// newExtraLabels sanitizes the server label keys into valid, unique
+// Prometheus label names, in a stable order.
+func newExtraLabels(keys []string) []extraLabel {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	used := make(map[string]bool, len(sorted))
+	labels := make([]extraLabel, 0, len(sorted))
+	for _, key := range sorted {
+		name := sanitizeLabelName(key)
+		if reservedLabels[name] || strings.HasPrefix(name, "__") {
+			name = "label_" + strings.TrimLeft(name, "_")
+		}
+		if used[name] {
+			slog.Warn("Skipping metric label that collides with another after sanitizing", "label", key, "name", name)
+			continue
+		}
+		used[name] = true
+		labels = append(labels, extraLabel{key: key, name: name})
+	}
+	return labels
+}
+
+func sanitizeLabelName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// labelGuard caps the number of distinct values of each extra label so a
+// label holding e.g. a random ID cannot blow up the number of series. Values
+// beyond the cap are reported as overflowValue. Each server holds a reference
+// on its values, so a value frees its slot once no server uses it anymore.
+type labelGuard struct {
+	maxValues int
+	refs      map[string]map[string]int
+	warned    map[string]bool
+}
+
+func newLabelGuard(maxValues int) *labelGuard {
+	return &labelGuard{
+		maxValues: maxValues,
+		refs:      make(map[string]map[string]int),
+		warned:    make(map[string]bool),
+	}
+}
+
+// acquire returns the value to export for a label and takes a reference on
+// it, to be dropped with release.
+func (g *labelGuard) acquire(name, value string) string {
+	if len(value) > maxValueLength {
+		value = value[:maxValueLength]
+		for !utf8.ValidString(value) {
+			value = value[:len(value)-1]
+		}
+	}
+
+	refs := g.refs[name]
+	if refs == nil {
+		refs = make(map[string]int)
+		g.refs[name] = refs
+	}
+	if refs[value] > 0 {
+		refs[value]++
+		return value
+	}
+
+	used := len(refs)
+	if refs[overflowValue] > 0 {
+		used--
+	}
+	if g.maxValues > 0 && used >= g.maxValues {
+		if !g.warned[name] {
+			slog.Warn("Metric label reached its value limit, reporting further values as other", "label", name, "limit", g.maxValues)
+			g.warned[name] = true
+		}
+		refs[overflowValue]++
+		return overflowValue
+	}
+	refs[value] = 1
+	return value
+}
+
+// release drops a reference taken by acquire.
+func (g *labelGuard) release(name, value string) {
+	refs := g.refs[name]
+	if refs[value] <= 0 {
+		return
+	}
+	refs[value]--
+	if refs[value] == 0 {
+		delete(refs, value)
+	}
+}
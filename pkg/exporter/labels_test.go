@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelGuardCap(t *testing.T) {
+	g := newLabelGuard(2)
+
+	if v := g.acquire("game", "rust"); v != "rust" {
+		t.Fatalf("acquire(rust) = %q", v)
+	}
+	if v := g.acquire("game", "ark"); v != "ark" {
+		t.Fatalf("acquire(ark) = %q", v)
+	}
+	// A value already in use never overflows.
+	if v := g.acquire("game", "rust"); v != "rust" {
+		t.Fatalf("second acquire(rust) = %q", v)
+	}
+	if v := g.acquire("game", "dayz"); v != overflowValue {
+		t.Fatalf("acquire(dayz) beyond the cap = %q, want %q", v, overflowValue)
+	}
+	// Other labels have their own cap.
+	if v := g.acquire("region", "eu"); v != "eu" {
+		t.Fatalf("acquire(eu) = %q", v)
+	}
+}
+
+func TestLabelGuardRelease(t *testing.T) {
+	g := newLabelGuard(1)
+
+	g.acquire("game", "rust")
+	g.acquire("game", "rust")
+	if v := g.acquire("game", "ark"); v != overflowValue {
+		t.Fatalf("acquire(ark) = %q, want %q", v, overflowValue)
+	}
+
+	// rust is still held by one server.
+	g.release("game", "rust")
+	g.release("game", overflowValue)
+	if v := g.acquire("game", "ark"); v != overflowValue {
+		t.Fatalf("acquire(ark) while rust is in use = %q, want %q", v, overflowValue)
+	}
+	g.release("game", overflowValue)
+
+	g.release("game", "rust")
+	if v := g.acquire("game", "ark"); v != "ark" {
+		t.Fatalf("acquire(ark) after rust was released = %q, want ark", v)
+	}
+
+	// Releasing values never acquired is harmless.
+	g.release("game", "unknown")
+	g.release("other", "rust")
+}
+
+func TestLabelGuardTruncates(t *testing.T) {
+	g := newLabelGuard(0)
+
+	long := strings.Repeat("a", maxValueLength-1) + "é"
+	v := g.acquire("game", long)
+	if len(v) != maxValueLength-1 {
+		t.Errorf("len = %d, want %d without the split rune", len(v), maxValueLength-1)
+	}
+}
@@ -1,28 +1,96 @@
 package exporter
 
 import (
+	"maps"
 	"net/http"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/ebpf"
 	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+	"github.com/rxtx-hosting/flowlens/pkg/pterodactyl"
 )
 
 type PrometheusExporter struct {
-	activePlayers *prometheus.GaugeVec
-	totalBytes    *prometheus.GaugeVec
-	cache         map[string]estimator.ServerPlayerStats
-	mu            sync.RWMutex
+	activePlayers         *prometheus.GaugeVec
+	activePlayersByFamily *prometheus.GaugeVec
+	actualPlayers         *prometheus.GaugeVec
+	activePlayerIPs       *prometheus.GaugeVec
+	totalBytes            *prometheus.GaugeVec
+	uploadBytes           *prometheus.GaugeVec
+	downloadBytes         *prometheus.GaugeVec
+	packetsPerSecond      *prometheus.GaugeVec
+	bytesPerSecond        *prometheus.GaugeVec
+	roleClients           *prometheus.GaugeVec
+	roleBytes             *prometheus.GaugeVec
+	estimatePlayers       *prometheus.GaugeVec
+	estimateConfidence    *prometheus.GaugeVec
+	sessionDuration       *prometheus.HistogramVec
+	sessionJoins          *prometheus.CounterVec
+	sessionLeaves         *prometheus.CounterVec
+	flowMapEntries        prometheus.Gauge
+	flowMapCapacity       prometheus.Gauge
+	flowMapUtilization    prometheus.Gauge
+	flowMapEvictions      prometheus.Gauge
+	flowGCRemoved         *prometheus.CounterVec
+	serverPanelInfo       *prometheus.GaugeVec
+	extraLabels           []extraLabel
+	labelGuard            *labelGuard
+	seriesLabels          map[string]prometheus.Labels
+	servers               map[string]bool
+	cache                 map[string]estimator.ServerPlayerStats
+	mu                    sync.RWMutex
 }
 
-func NewPrometheusExporter() *PrometheusExporter {
+// NewPrometheusExporter creates the exporter. The server labels named in
+// labelKeys become extra labels on the per-server gauges, each limited to
+// maxLabelValues distinct values (0 for no limit).
+func NewPrometheusExporter(labelKeys []string, maxLabelValues int) *PrometheusExporter {
+	return newPrometheusExporter(prometheus.DefaultRegisterer, labelKeys, maxLabelValues)
+}
+
+func newPrometheusExporter(reg prometheus.Registerer, labelKeys []string, maxLabelValues int) *PrometheusExporter {
+	extraLabels := newExtraLabels(labelKeys)
+	serverLabels := []string{"server_id"}
+	for _, l := range extraLabels {
+		serverLabels = append(serverLabels, l.name)
+	}
+	withLabel := func(name string) []string {
+		return append(append([]string(nil), serverLabels...), name)
+	}
+
 	activePlayers := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "flowlens_active_players",
 			Help: "Number of active players on game server",
 		},
-		[]string{"server_id"},
+		serverLabels,
+	)
+
+	activePlayerIPs := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_active_player_ips",
+			Help: "Number of distinct addresses active players connect from",
+		},
+		serverLabels,
+	)
+
+	actualPlayers := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_actual_players",
+			Help: "Player count reported by the game server itself, when calibration is enabled",
+		},
+		serverLabels,
+	)
+
+	activePlayersByFamily := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_active_players_by_family",
+			Help: "Number of active players on game server by IP address family",
+		},
+		withLabel("family"),
 	)
 
 	totalBytes := prometheus.NewGaugeVec(
@@ -30,16 +98,185 @@ func NewPrometheusExporter() *PrometheusExporter {
 			Name: "flowlens_total_bytes",
 			Help: "Total bytes transferred in sample window",
 		},
+		serverLabels,
+	)
+
+	uploadBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_upload_bytes",
+			Help: "Bytes sent by clients to all ports of game server in sample window",
+		},
+		serverLabels,
+	)
+
+	downloadBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_download_bytes",
+			Help: "Bytes sent by game server to clients in sample window, when egress is monitored",
+		},
+		serverLabels,
+	)
+
+	packetsPerSecond := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_packets_per_second",
+			Help: "Ingress packet rate to game server over the last metrics interval",
+		},
+		serverLabels,
+	)
+
+	bytesPerSecond := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_bytes_per_second",
+			Help: "Ingress byte rate to game server over the last metrics interval",
+		},
+		serverLabels,
+	)
+
+	roleClients := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_role_clients",
+			Help: "Number of clients seen on non-game ports (query, rcon, voice) of game server",
+		},
+		withLabel("role"),
+	)
+
+	roleBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_role_bytes",
+			Help: "Bytes transferred on non-game ports (query, rcon, voice) in sample window",
+		},
+		withLabel("role"),
+	)
+
+	estimatePlayers := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_estimate_players",
+			Help: "Number of players each estimator strategy finds on game server",
+		},
+		withLabel("strategy"),
+	)
+
+	estimateConfidence := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_estimate_confidence",
+			Help: "Confidence of each estimator strategy in its player count, from 0 to 1",
+		},
+		withLabel("strategy"),
+	)
+
+	sessionDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "flowlens_session_duration_seconds",
+			Help:    "Duration of finished player sessions",
+			Buckets: []float64{60, 300, 600, 1800, 3600, 7200, 14400, 28800},
+		},
 		[]string{"server_id"},
 	)
 
-	prometheus.MustRegister(activePlayers)
-	prometheus.MustRegister(totalBytes)
+	sessionJoins := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flowlens_session_joins_total",
+			Help: "Number of player sessions started",
+		},
+		[]string{"server_id"},
+	)
+
+	sessionLeaves := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flowlens_session_leaves_total",
+			Help: "Number of player sessions finished",
+		},
+		[]string{"server_id"},
+	)
+
+	flowMapEntries := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "flowlens_flow_map_entries",
+		Help: "Number of entries in the flow_stats eBPF map",
+	})
+
+	flowMapCapacity := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "flowlens_flow_map_capacity",
+		Help: "Maximum number of entries in the flow_stats eBPF map",
+	})
+
+	flowMapUtilization := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "flowlens_flow_map_utilization_ratio",
+		Help: "Fraction of the flow_stats eBPF map in use",
+	})
+
+	flowMapEvictions := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "flowlens_flow_map_estimated_evictions",
+		Help: "Estimated number of flows evicted by the flow_stats LRU since it was created",
+	})
+
+	flowGCRemoved := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flowlens_flow_gc_removed_total",
+			Help: "Flows removed from the flow_stats eBPF map by garbage collection",
+		},
+		[]string{"reason"},
+	)
+
+	serverPanelInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flowlens_server_panel_info",
+			Help: "Pterodactyl/Pelican panel metadata of game server, always 1",
+		},
+		[]string{"server_id", "name", "owner", "egg", "node"},
+	)
+
+	reg.MustRegister(activePlayers)
+	reg.MustRegister(activePlayersByFamily)
+	reg.MustRegister(activePlayerIPs)
+	reg.MustRegister(actualPlayers)
+	reg.MustRegister(totalBytes)
+	reg.MustRegister(uploadBytes)
+	reg.MustRegister(downloadBytes)
+	reg.MustRegister(packetsPerSecond)
+	reg.MustRegister(bytesPerSecond)
+	reg.MustRegister(roleClients)
+	reg.MustRegister(roleBytes)
+	reg.MustRegister(estimatePlayers)
+	reg.MustRegister(estimateConfidence)
+	reg.MustRegister(sessionDuration)
+	reg.MustRegister(sessionJoins)
+	reg.MustRegister(sessionLeaves)
+	reg.MustRegister(flowMapEntries)
+	reg.MustRegister(flowMapCapacity)
+	reg.MustRegister(flowMapUtilization)
+	reg.MustRegister(flowMapEvictions)
+	reg.MustRegister(flowGCRemoved)
+	reg.MustRegister(serverPanelInfo)
 
 	return &PrometheusExporter{
-		activePlayers: activePlayers,
-		totalBytes:    totalBytes,
-		cache:         make(map[string]estimator.ServerPlayerStats),
+		activePlayers:         activePlayers,
+		activePlayersByFamily: activePlayersByFamily,
+		actualPlayers:         actualPlayers,
+		activePlayerIPs:       activePlayerIPs,
+		totalBytes:            totalBytes,
+		uploadBytes:           uploadBytes,
+		downloadBytes:         downloadBytes,
+		packetsPerSecond:      packetsPerSecond,
+		bytesPerSecond:        bytesPerSecond,
+		roleClients:           roleClients,
+		roleBytes:             roleBytes,
+		estimatePlayers:       estimatePlayers,
+		estimateConfidence:    estimateConfidence,
+		sessionDuration:       sessionDuration,
+		sessionJoins:          sessionJoins,
+		sessionLeaves:         sessionLeaves,
+		flowMapEntries:        flowMapEntries,
+		flowMapCapacity:       flowMapCapacity,
+		flowMapUtilization:    flowMapUtilization,
+		flowMapEvictions:      flowMapEvictions,
+		flowGCRemoved:         flowGCRemoved,
+		serverPanelInfo:       serverPanelInfo,
+		extraLabels:           extraLabels,
+		labelGuard:            newLabelGuard(maxLabelValues),
+		seriesLabels:          make(map[string]prometheus.Labels),
+		servers:               make(map[string]bool),
+		cache:                 make(map[string]estimator.ServerPlayerStats),
 	}
 }
 
@@ -51,20 +288,157 @@ func (p *PrometheusExporter) UpdateStats(stats []estimator.ServerPlayerStats) {
 
 	for _, stat := range stats {
 		newCache[stat.ServerID] = stat
-		p.activePlayers.WithLabelValues(stat.ServerID).Set(float64(stat.ActivePlayers))
-		p.totalBytes.WithLabelValues(stat.ServerID).Set(float64(stat.TotalBytes))
+
+		old, ok := p.seriesLabels[stat.ServerID]
+		p.releaseLabels(old)
+		labels := p.serverLabels(stat)
+		if ok && !maps.Equal(old, labels) {
+			p.deleteServer(stat.ServerID)
+		}
+		p.seriesLabels[stat.ServerID] = labels
+
+		p.activePlayers.With(labels).Set(float64(stat.ActivePlayers))
+		p.activePlayersByFamily.With(with(labels, "family", "ipv4")).Set(float64(stat.IPv4Players))
+		p.activePlayersByFamily.With(with(labels, "family", "ipv6")).Set(float64(stat.IPv6Players))
+		p.activePlayerIPs.With(labels).Set(float64(len(stat.UniqueIPs)))
+		if stat.Calibrated {
+			p.actualPlayers.With(labels).Set(float64(stat.ActualPlayers))
+		}
+		p.totalBytes.With(labels).Set(float64(stat.TotalBytes))
+		p.uploadBytes.With(labels).Set(float64(stat.UploadBytes))
+		p.downloadBytes.With(labels).Set(float64(stat.DownloadBytes))
+		p.packetsPerSecond.With(labels).Set(stat.PacketsPerSecond)
+		p.bytesPerSecond.With(labels).Set(stat.BytesPerSecond)
+
+		p.roleClients.DeletePartialMatch(prometheus.Labels{"server_id": stat.ServerID})
+		p.roleBytes.DeletePartialMatch(prometheus.Labels{"server_id": stat.ServerID})
+		for role, rs := range stat.Roles {
+			p.roleClients.With(with(labels, "role", string(role))).Set(float64(rs.Clients))
+			p.roleBytes.With(with(labels, "role", string(role))).Set(float64(rs.Bytes))
+		}
+
+		for strategy, sr := range stat.Strategies {
+			p.estimatePlayers.With(with(labels, "strategy", strategy)).Set(float64(sr.Players))
+			p.estimateConfidence.With(with(labels, "strategy", strategy)).Set(sr.Confidence)
+		}
+
+		p.serverPanelInfo.DeletePartialMatch(prometheus.Labels{"server_id": stat.ServerID})
+		if name, ok := stat.Labels[pterodactyl.LabelName]; ok {
+			p.serverPanelInfo.WithLabelValues(stat.ServerID, name, stat.Labels[pterodactyl.LabelOwner], stat.Labels[pterodactyl.LabelEgg], stat.Labels[pterodactyl.LabelNode]).Set(1)
+		}
 	}
 
 	for serverID := range p.cache {
 		if _, exists := newCache[serverID]; !exists {
-			p.activePlayers.DeleteLabelValues(serverID)
-			p.totalBytes.DeleteLabelValues(serverID)
+			p.deleteServer(serverID)
+			p.serverPanelInfo.DeletePartialMatch(prometheus.Labels{"server_id": serverID})
+			p.releaseLabels(p.seriesLabels[serverID])
+			delete(p.seriesLabels, serverID)
 		}
 	}
 
 	p.cache = newCache
 }
 
+// serverLabels returns the label set of a server's gauges, taking guard
+// references on its values. Missing labels are empty.
+func (p *PrometheusExporter) serverLabels(stat estimator.ServerPlayerStats) prometheus.Labels {
+	labels := prometheus.Labels{"server_id": stat.ServerID}
+	for _, l := range p.extraLabels {
+		labels[l.name] = ""
+		if v := stat.Labels[l.key]; v != "" {
+			labels[l.name] = p.labelGuard.acquire(l.name, v)
+		}
+	}
+	return labels
+}
+
+// releaseLabels drops the guard references taken by serverLabels.
+func (p *PrometheusExporter) releaseLabels(labels prometheus.Labels) {
+	for _, l := range p.extraLabels {
+		if v, ok := labels[l.name]; ok && v != "" {
+			p.labelGuard.release(l.name, v)
+		}
+	}
+}
+
+func (p *PrometheusExporter) deleteServer(serverID string) {
+	match := prometheus.Labels{"server_id": serverID}
+	p.activePlayers.DeletePartialMatch(match)
+	p.activePlayersByFamily.DeletePartialMatch(match)
+	p.actualPlayers.DeletePartialMatch(match)
+	p.activePlayerIPs.DeletePartialMatch(match)
+	p.totalBytes.DeletePartialMatch(match)
+	p.uploadBytes.DeletePartialMatch(match)
+	p.downloadBytes.DeletePartialMatch(match)
+	p.packetsPerSecond.DeletePartialMatch(match)
+	p.bytesPerSecond.DeletePartialMatch(match)
+	p.roleClients.DeletePartialMatch(match)
+	p.roleBytes.DeletePartialMatch(match)
+	p.estimatePlayers.DeletePartialMatch(match)
+	p.estimateConfidence.DeletePartialMatch(match)
+}
+
+func with(labels prometheus.Labels, name, value string) prometheus.Labels {
+	out := make(prometheus.Labels, len(labels)+1)
+	maps.Copy(out, labels)
+	out[name] = value
+	return out
+}
+
+// UpdateServers deletes the session metrics of servers that discovery no
+// longer reports. They outlive the gauges, which go once a server has no
+// flows, so idle servers keep their session history.
+func (p *PrometheusExporter) UpdateServers(servers []discovery.ServerMetadata) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]bool, len(servers))
+	for _, srv := range servers {
+		current[srv.ServerID] = true
+	}
+	for serverID := range p.servers {
+		if !current[serverID] {
+			p.sessionJoins.DeleteLabelValues(serverID)
+			p.sessionLeaves.DeleteLabelValues(serverID)
+			p.sessionDuration.DeleteLabelValues(serverID)
+		}
+	}
+	p.servers = current
+}
+
+// UpdateSessions ignores servers that discovery dropped, so their last
+// leaves do not bring the deleted series back.
+func (p *PrometheusExporter) UpdateSessions(update estimator.SessionUpdate) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, s := range update.Joined {
+		if p.servers[s.ServerID] {
+			p.sessionJoins.WithLabelValues(s.ServerID).Inc()
+		}
+	}
+	for _, s := range update.Left {
+		if !p.servers[s.ServerID] {
+			continue
+		}
+		p.sessionLeaves.WithLabelValues(s.ServerID).Inc()
+		p.sessionDuration.WithLabelValues(s.ServerID).Observe(s.Duration().Seconds())
+	}
+}
+
+func (p *PrometheusExporter) UpdateMapPressure(pressure ebpf.MapPressure) {
+	p.flowMapEntries.Set(float64(pressure.Entries))
+	p.flowMapCapacity.Set(float64(pressure.Capacity))
+	p.flowMapUtilization.Set(pressure.Utilization)
+	p.flowMapEvictions.Set(float64(pressure.EstimatedEvictions))
+}
+
+func (p *PrometheusExporter) UpdateGC(stats ebpf.GCStats) {
+	p.flowGCRemoved.WithLabelValues("stale").Add(float64(stats.Stale))
+	p.flowGCRemoved.WithLabelValues("unmonitored").Add(float64(stats.Unmonitored))
+}
+
 func (p *PrometheusExporter) StartServer(addr string) error {
 	http.Handle("/metrics", promhttp.Handler())
 	return http.ListenAndServe(addr, nil)
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"github.com/rxtx-hosting/flowlens/pkg/estimator"
+)
+
+func TestReservedLabelKeys(t *testing.T) {
+	var keys []string
+	labels := make(map[string]string)
+	for name := range reservedLabels {
+		keys = append(keys, name)
+		labels[name] = "user"
+	}
+
+	reg := prometheus.NewRegistry()
+	p := newPrometheusExporter(reg, keys, 0)
+	p.UpdateStats([]estimator.ServerPlayerStats{{
+		ServerID:   "srv",
+		Labels:     labels,
+		Roles:      map[discovery.PortRole]estimator.RoleStats{discovery.RoleGame: {Clients: 1}},
+		Strategies: map[string]estimator.StrategyResult{"threshold": {Players: 1}},
+	}})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			seen := make(map[string]bool)
+			for _, lp := range m.GetLabel() {
+				if seen[lp.GetName()] {
+					t.Errorf("%s has label %q twice", mf.GetName(), lp.GetName())
+				}
+				seen[lp.GetName()] = true
+			}
+			if mf.GetName() == "flowlens_active_players" && !seen["label_strategy"] {
+				t.Errorf("%s lacks label_strategy: %v", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+}
+
+// sessionSamples returns the session leaves and the number of observed
+// session durations of a server.
+func sessionSamples(t *testing.T, reg *prometheus.Registry, serverID string) (leaves float64, durations uint64) {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if len(m.GetLabel()) == 0 || m.GetLabel()[0].GetValue() != serverID {
+				continue
+			}
+			switch mf.GetName() {
+			case "flowlens_session_leaves_total":
+				leaves = m.GetCounter().GetValue()
+			case "flowlens_session_duration_seconds":
+				durations = m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return leaves, durations
+}
+
+func TestSessionsOutliveLastPlayer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := newPrometheusExporter(reg, nil, 0)
+	servers := []discovery.ServerMetadata{{ServerID: "srv"}}
+	p.UpdateServers(servers)
+
+	joined := time.Now()
+	session := estimator.Session{ServerID: "srv", ClientIP: "192.0.2.1", Joined: joined, LastSeen: joined}
+	p.UpdateSessions(estimator.SessionUpdate{Joined: []estimator.Session{session}, Active: []estimator.Session{session}})
+	p.UpdateStats([]estimator.ServerPlayerStats{{ServerID: "srv", ActivePlayers: 1}})
+
+	// The last player goes idle and the server drops out of the stats.
+	session.LastSeen = joined.Add(time.Minute)
+	session.Left = session.LastSeen
+	p.UpdateSessions(estimator.SessionUpdate{Left: []estimator.Session{session}})
+	p.UpdateStats(nil)
+	p.UpdateServers(servers)
+
+	if leaves, durations := sessionSamples(t, reg, "srv"); leaves != 1 || durations != 1 {
+		t.Fatalf("leaves, durations = %v, %d, want 1, 1", leaves, durations)
+	}
+
+	// Discovery drops the server, late leaves do not bring it back.
+	p.UpdateServers(nil)
+	p.UpdateSessions(estimator.SessionUpdate{Left: []estimator.Session{session}})
+	if leaves, durations := sessionSamples(t, reg, "srv"); leaves != 0 || durations != 0 {
+		t.Errorf("leaves, durations after removal = %v, %d, want 0, 0", leaves, durations)
+	}
+}
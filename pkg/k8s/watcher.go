@@ -0,0 +1,378 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type Options struct {
+	// NodeName restricts discovery to pods scheduled on this node.
+	NodeName string
+	// LabelSelector filters game server pods, e.g. "app=gameserver".
+	LabelSelector string
+	// ServerIDSource is one of name, uid, label:KEY or annotation:KEY.
+	ServerIDSource string
+	// PortsAnnotation holds a port spec that overrides the container ports.
+	PortsAnnotation string
+	// NodePortServices maps the node ports of services selecting a game
+	// server pod to that pod. Services are only watched in the namespaces of
+	// the game server pods on the node.
+	NodePortServices bool
+	// LabelSources sets the labels reported for each server, keyed by label
+	// name. A source is name, namespace, image (of the first container),
+	// label:KEY or annotation:KEY.
+	LabelSources map[string]string
+	// ProfileSource is where a server's threshold profile name is read from,
+	// label:KEY or annotation:KEY.
+	ProfileSource  string
+	ResyncInterval time.Duration
+}
+
+// Watcher discovers game servers from the pods running on one node.
+type Watcher struct {
+	client kubernetes.Interface
+	opts   Options
+}
+
+// RESTConfig loads kubeconfig, or the in-cluster service account when
+// kubeconfig is empty.
+func RESTConfig(kubeconfig string) (*rest.Config, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfig == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
+	}
+	return cfg, nil
+}
+
+// NewClientset connects to the API server, see RESTConfig.
+func NewClientset(kubeconfig string) (kubernetes.Interface, error) {
+	cfg, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+func NewWatcher(client kubernetes.Interface, opts Options) *Watcher {
+	return &Watcher{
+		client: client,
+		opts:   opts,
+	}
+}
+
+func (w *Watcher) Name() string {
+	return "kubernetes"
+}
+
+// Run sends the full server set on updates every time a watched pod or
+// service changes. Reconnects are handled by the informers.
+func (w *Watcher) Run(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	podFactory := informers.NewSharedInformerFactoryWithOptions(w.client, w.opts.ResyncInterval,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = "spec.nodeName=" + w.opts.NodeName
+			o.LabelSelector = w.opts.LabelSelector
+		}),
+	)
+	podInformer := podFactory.Core().V1().Pods()
+
+	changed := make(chan struct{}, 1)
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify(changed) },
+		UpdateFunc: func(any, any) { notify(changed) },
+		DeleteFunc: func(any) { notify(changed) },
+	}
+
+	podInformer.Informer().AddEventHandler(handler)
+
+	var services *serviceInformers
+	if w.opts.NodePortServices {
+		services = &serviceInformers{
+			client:  w.client,
+			resync:  w.opts.ResyncInterval,
+			handler: handler,
+			byNS:    make(map[string]*namespaceServices),
+		}
+		defer services.stop()
+	}
+
+	podFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced) {
+		return
+	}
+
+	for {
+		servers, err := w.servers(ctx, podInformer.Lister(), services)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error listing pods", "error", err)
+		} else {
+			select {
+			case updates <- servers:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func notify(changed chan<- struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// serviceInformers watches Services only in the namespaces of the game
+// server pods on this node, so the pod on every node does not hold every
+// Service of the cluster.
+type serviceInformers struct {
+	client  kubernetes.Interface
+	resync  time.Duration
+	handler cache.ResourceEventHandler
+	byNS    map[string]*namespaceServices
+}
+
+type namespaceServices struct {
+	lister listersv1.ServiceLister
+	cancel context.CancelFunc
+}
+
+// update starts informers for namespaces that gained a pod and stops those of
+// namespaces that lost their last one, then waits for new informers to sync.
+func (s *serviceInformers) update(ctx context.Context, namespaces map[string]bool) error {
+	var synced []cache.InformerSynced
+	for ns := range namespaces {
+		if _, ok := s.byNS[ns]; ok {
+			continue
+		}
+		nsCtx, cancel := context.WithCancel(ctx)
+		factory := informers.NewSharedInformerFactoryWithOptions(s.client, s.resync, informers.WithNamespace(ns))
+		informer := factory.Core().V1().Services()
+		informer.Informer().AddEventHandler(s.handler)
+		factory.Start(nsCtx.Done())
+
+		s.byNS[ns] = &namespaceServices{lister: informer.Lister(), cancel: cancel}
+		synced = append(synced, informer.Informer().HasSynced)
+	}
+
+	for ns, svc := range s.byNS {
+		if !namespaces[ns] {
+			svc.cancel()
+			delete(s.byNS, ns)
+		}
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync services: %w", ctx.Err())
+	}
+	return nil
+}
+
+// list returns the Services of a namespace, none if it is not watched.
+func (s *serviceInformers) list(namespace string) ([]*corev1.Service, error) {
+	if s == nil {
+		return nil, nil
+	}
+	svc, ok := s.byNS[namespace]
+	if !ok {
+		return nil, nil
+	}
+	return svc.lister.Services(namespace).List(labels.Everything())
+}
+
+func (s *serviceInformers) stop() {
+	for _, svc := range s.byNS {
+		svc.cancel()
+	}
+}
+
+func (w *Watcher) servers(ctx context.Context, pods listersv1.PodLister, services *serviceInformers) ([]discovery.ServerMetadata, error) {
+	podList, err := pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	if services != nil {
+		namespaces := make(map[string]bool)
+		for _, pod := range podList {
+			namespaces[pod.Namespace] = true
+		}
+		if err := services.update(ctx, namespaces); err != nil {
+			return nil, err
+		}
+	}
+
+	servers := make([]discovery.ServerMetadata, 0, len(podList))
+	for _, pod := range podList {
+		svcList, err := services.list(pod.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if srv, ok := w.serverFromPod(pod, svcList); ok {
+			servers = append(servers, srv)
+		}
+	}
+	return servers, nil
+}
+
+func (w *Watcher) serverFromPod(pod *corev1.Pod, services []*corev1.Service) (discovery.ServerMetadata, bool) {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return discovery.ServerMetadata{}, false
+	}
+
+	// The field selector already does this on the API server.
+	if w.opts.NodeName != "" && pod.Spec.NodeName != w.opts.NodeName {
+		return discovery.ServerMetadata{}, false
+	}
+
+	serverID := w.extractID(pod)
+	if serverID == "" {
+		return discovery.ServerMetadata{}, false
+	}
+
+	ports := w.extractPorts(pod, services)
+	if len(ports) == 0 {
+		return discovery.ServerMetadata{}, false
+	}
+
+	var gamePort int
+	for _, r := range ports {
+		if r.Role == discovery.RoleGame {
+			gamePort = r.Start
+			break
+		}
+	}
+
+	labels := discovery.ResolveLabels(w.opts.LabelSources, func(source string) (string, bool) {
+		return podSource(pod, source)
+	})
+
+	image, _ := podSource(pod, "image")
+	profile, _ := podSource(pod, w.opts.ProfileSource)
+
+	return discovery.ServerMetadata{
+		ServerID:      serverID,
+		GamePort:      gamePort,
+		Ports:         ports,
+		ContainerID:   string(pod.UID),
+		ContainerName: pod.Namespace + "/" + pod.Name,
+		Image:         image,
+		Profile:       profile,
+		Labels:        labels,
+		LastUpdated:   time.Now(),
+	}, true
+}
+
+func podSource(pod *corev1.Pod, source string) (string, bool) {
+	switch {
+	case source == "name":
+		return pod.Name, true
+	case source == "namespace":
+		return pod.Namespace, true
+	case source == "image":
+		if len(pod.Spec.Containers) == 0 {
+			return "", false
+		}
+		return pod.Spec.Containers[0].Image, true
+	case strings.HasPrefix(source, "label:"):
+		val, ok := pod.Labels[strings.TrimPrefix(source, "label:")]
+		return val, ok
+	case strings.HasPrefix(source, "annotation:"):
+		val, ok := pod.Annotations[strings.TrimPrefix(source, "annotation:")]
+		return val, ok
+	}
+	return "", false
+}
+
+func (w *Watcher) extractID(pod *corev1.Pod) string {
+	switch src := w.opts.ServerIDSource; {
+	case src == "uid":
+		return string(pod.UID)
+	case strings.HasPrefix(src, "label:"):
+		return pod.Labels[strings.TrimPrefix(src, "label:")]
+	case strings.HasPrefix(src, "annotation:"):
+		return pod.Annotations[strings.TrimPrefix(src, "annotation:")]
+	default:
+		return pod.Name
+	}
+}
+
+// extractPorts reads the port spec annotation if present. Otherwise it uses
+// the hostPort of every container port (the containerPort on hostNetwork
+// pods) plus the node ports of services selecting the pod. The port name
+// picks the role, e.g. "query" or "query-steam".
+func (w *Watcher) extractPorts(pod *corev1.Pod, services []*corev1.Service) []discovery.PortRange {
+	if spec, ok := pod.Annotations[w.opts.PortsAnnotation]; ok && w.opts.PortsAnnotation != "" {
+		ports, err := discovery.ParsePortSpec(spec)
+		if err == nil {
+			return ports
+		}
+		slog.Warn("Ignoring invalid port spec", "pod", pod.Namespace+"/"+pod.Name, "spec", spec, "error", err)
+	}
+
+	var ports []discovery.PortRange
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			port := p.HostPort
+			if port == 0 && pod.Spec.HostNetwork {
+				port = p.ContainerPort
+			}
+			if port <= 0 {
+				continue
+			}
+			ports = append(ports, discovery.PortRange{Start: int(port), End: int(port), Role: discovery.RoleFromName(p.Name)})
+		}
+	}
+
+	for _, svc := range services {
+		if svc.Namespace != pod.Namespace || len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		if !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, p := range svc.Spec.Ports {
+			if p.NodePort > 0 {
+				ports = append(ports, discovery.PortRange{Start: int(p.NodePort), End: int(p.NodePort), Role: discovery.RoleFromName(p.Name)})
+			}
+		}
+	}
+
+	return ports
+}
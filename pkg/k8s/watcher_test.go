@@ -0,0 +1,190 @@
+package k8s
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNode = "node-a"
+
+func gamePod(namespace, name, node string, annotations map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			UID:         types.UID("uid-" + name),
+			Labels:      map[string]string{"app": "gameserver", "instance": name},
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   node,
+			Containers: []corev1.Container{{Name: "game", Image: "example/game:1", Ports: ports}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func nodePortService(namespace, name string, selector map[string]string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: selector,
+			Ports:    ports,
+		},
+	}
+}
+
+// firstUpdate runs a Watcher against objects and returns its first server
+// set, sorted by server ID.
+func firstUpdate(t *testing.T, opts Options, objects ...runtime.Object) ([]discovery.ServerMetadata, *fake.Clientset) {
+	t.Helper()
+
+	client := fake.NewSimpleClientset(objects...)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	updates := make(chan []discovery.ServerMetadata, 1)
+	go NewWatcher(client, opts).Run(ctx, updates)
+
+	select {
+	case servers := <-updates:
+		sort.Slice(servers, func(i, j int) bool { return servers[i].ServerID < servers[j].ServerID })
+		return servers, client
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update from watcher")
+		return nil, nil
+	}
+}
+
+func TestPortsAnnotation(t *testing.T) {
+	opts := Options{NodeName: testNode, PortsAnnotation: "flowlens.io/ports"}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []discovery.PortRange
+	}{
+		{
+			name:        "annotation overrides container ports",
+			annotations: map[string]string{"flowlens.io/ports": "game:27015, query:27016,game:28000-28002"},
+			want: []discovery.PortRange{
+				{Start: 27015, End: 27015, Role: discovery.RoleGame},
+				{Start: 27016, End: 27016, Role: discovery.RoleQuery},
+				{Start: 28000, End: 28002, Role: discovery.RoleGame},
+			},
+		},
+		{
+			name:        "invalid annotation falls back to host ports",
+			annotations: map[string]string{"flowlens.io/ports": "game:notaport"},
+			want:        []discovery.PortRange{{Start: 7777, End: 7777, Role: discovery.RoleGame}},
+		},
+		{
+			name: "no annotation uses host ports",
+			want: []discovery.PortRange{{Start: 7777, End: 7777, Role: discovery.RoleGame}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := gamePod("games", "srv", testNode, tt.annotations, corev1.ContainerPort{Name: "game", ContainerPort: 7777, HostPort: 7777})
+			servers, _ := firstUpdate(t, opts, pod)
+			if len(servers) != 1 {
+				t.Fatalf("got %d servers, want 1", len(servers))
+			}
+			assertPorts(t, servers[0].Ports, tt.want)
+		})
+	}
+}
+
+func TestNodePortServices(t *testing.T) {
+	opts := Options{NodeName: testNode, NodePortServices: true}
+
+	pod := gamePod("games", "srv", testNode, nil, corev1.ContainerPort{Name: "game", ContainerPort: 7777})
+	servers, client := firstUpdate(t, opts,
+		pod,
+		nodePortService("games", "srv-game", map[string]string{"instance": "srv"},
+			corev1.ServicePort{Name: "game", Port: 7777, NodePort: 30777},
+			corev1.ServicePort{Name: "query-steam", Port: 27016, NodePort: 30016},
+		),
+		// Selects another pod.
+		nodePortService("games", "other", map[string]string{"instance": "other"},
+			corev1.ServicePort{Name: "game", Port: 7777, NodePort: 30800},
+		),
+		// Same selector, but a namespace without game servers on the node.
+		nodePortService("elsewhere", "srv-game", map[string]string{"instance": "srv"},
+			corev1.ServicePort{Name: "game", Port: 7777, NodePort: 30900},
+		),
+	)
+
+	if len(servers) != 1 {
+		t.Fatalf("got %d servers, want 1", len(servers))
+	}
+	assertPorts(t, servers[0].Ports, []discovery.PortRange{
+		{Start: 30777, End: 30777, Role: discovery.RoleGame},
+		{Start: 30016, End: 30016, Role: discovery.RoleQuery},
+	})
+	if servers[0].GamePort != 30777 {
+		t.Errorf("GamePort = %d, want 30777", servers[0].GamePort)
+	}
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "services" && action.GetNamespace() != "games" {
+			t.Errorf("%s services in namespace %q, want only games", action.GetVerb(), action.GetNamespace())
+		}
+	}
+}
+
+func TestNodeFiltering(t *testing.T) {
+	opts := Options{NodeName: testNode}
+	port := corev1.ContainerPort{Name: "game", ContainerPort: 7777, HostPort: 7777}
+
+	pending := gamePod("games", "pending", testNode, nil, port)
+	pending.Status.Phase = corev1.PodPending
+
+	deleting := gamePod("games", "deleting", testNode, nil, port)
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	servers, _ := firstUpdate(t, opts,
+		gamePod("games", "local", testNode, nil, port),
+		gamePod("games", "remote", "node-b", nil, port),
+		pending,
+		deleting,
+	)
+
+	if len(servers) != 1 || servers[0].ServerID != "local" {
+		t.Fatalf("got servers %v, want only local", serverIDs(servers))
+	}
+	if servers[0].ContainerName != "games/local" {
+		t.Errorf("ContainerName = %q, want games/local", servers[0].ContainerName)
+	}
+}
+
+func assertPorts(t *testing.T, got, want []discovery.PortRange) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("ports = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ports = %v, want %v", got, want)
+		}
+	}
+}
+
+func serverIDs(servers []discovery.ServerMetadata) []string {
+	ids := make([]string, len(servers))
+	for i, s := range servers {
+		ids[i] = s.ServerID
+	}
+	return ids
+}
@@ -0,0 +1,156 @@
+package pterodactyl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const perPage = 100
+
+// Server is what the panel knows about a game server. Pelican exposes the
+// same application API as Pterodactyl.
+type Server struct {
+	UUID       string
+	Identifier string
+	Name       string
+	Owner      string
+	Egg        string
+	Node       string
+	Ports      []Allocation
+}
+
+type Allocation struct {
+	Port  int
+	Notes string
+}
+
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// NewClient talks to the application API of the panel at baseURL using an
+// application API key. httpClient may be nil.
+func NewClient(baseURL, apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    httpClient,
+	}
+}
+
+type serverList struct {
+	Data []struct {
+		Attributes serverAttributes `json:"attributes"`
+	} `json:"data"`
+	Meta struct {
+		Pagination struct {
+			CurrentPage int `json:"current_page"`
+			TotalPages  int `json:"total_pages"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+type serverAttributes struct {
+	UUID          string `json:"uuid"`
+	Identifier    string `json:"identifier"`
+	Name          string `json:"name"`
+	Relationships struct {
+		Allocations struct {
+			Data []struct {
+				Attributes struct {
+					Port  int    `json:"port"`
+					Notes string `json:"notes"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"allocations"`
+		User struct {
+			Attributes struct {
+				Username string `json:"username"`
+			} `json:"attributes"`
+		} `json:"user"`
+		Egg struct {
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"egg"`
+		Node struct {
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"node"`
+	} `json:"relationships"`
+}
+
+// Servers lists every server on the panel, keyed by UUID.
+func (c *Client) Servers(ctx context.Context) (map[string]Server, error) {
+	servers := make(map[string]Server)
+
+	for page := 1; ; page++ {
+		list, err := c.serverPage(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range list.Data {
+			a := d.Attributes
+			srv := Server{
+				UUID:       a.UUID,
+				Identifier: a.Identifier,
+				Name:       a.Name,
+				Owner:      a.Relationships.User.Attributes.Username,
+				Egg:        a.Relationships.Egg.Attributes.Name,
+				Node:       a.Relationships.Node.Attributes.Name,
+			}
+			for _, alloc := range a.Relationships.Allocations.Data {
+				srv.Ports = append(srv.Ports, Allocation{Port: alloc.Attributes.Port, Notes: alloc.Attributes.Notes})
+			}
+			servers[srv.UUID] = srv
+		}
+
+		if page >= list.Meta.Pagination.TotalPages {
+			return servers, nil
+		}
+	}
+}
+
+func (c *Client) serverPage(ctx context.Context, page int) (*serverList, error) {
+	query := url.Values{
+		"include":  {"allocations,user,egg,node"},
+		"per_page": {strconv.Itoa(perPage)},
+		"page":     {strconv.Itoa(page)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/application/servers?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list panel servers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list panel servers: %s", resp.Status)
+	}
+
+	var list serverList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode panel servers: %w", err)
+	}
+	return &list, nil
+}
@@ -0,0 +1,251 @@
+package pterodactyl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+const testKey = "ptla_secret"
+
+// fakePanel serves the application API with pages of servers. While fail is
+// set it answers every request with 500.
+type fakePanel struct {
+	t        *testing.T
+	pages    [][]map[string]any
+	requests atomic.Int32
+	fail     atomic.Bool
+}
+
+func (p *fakePanel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.requests.Add(1)
+
+	if got := r.Header.Get("Authorization"); got != "Bearer "+testKey {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	if p.fail.Load() {
+		http.Error(w, "panel down", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Path != "/api/application/servers" {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("include") != "allocations,user,egg,node" || q.Get("per_page") != strconv.Itoa(perPage) {
+		p.t.Errorf("unexpected query %q", r.URL.RawQuery)
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 || page > len(p.pages) {
+		http.Error(w, "bad page", http.StatusBadRequest)
+		return
+	}
+
+	data := make([]map[string]any, 0, len(p.pages[page-1]))
+	for _, attrs := range p.pages[page-1] {
+		data = append(data, map[string]any{"object": "server", "attributes": attrs})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"data": data,
+		"meta": map[string]any{
+			"pagination": map[string]any{"current_page": page, "total_pages": len(p.pages)},
+		},
+	})
+}
+
+func panelServer(uuid, identifier, name string, ports ...any) map[string]any {
+	allocs := make([]any, 0, len(ports)/2)
+	for i := 0; i+1 < len(ports); i += 2 {
+		allocs = append(allocs, map[string]any{
+			"attributes": map[string]any{"port": ports[i], "notes": ports[i+1]},
+		})
+	}
+	return map[string]any{
+		"uuid":       uuid,
+		"identifier": identifier,
+		"name":       name,
+		"relationships": map[string]any{
+			"allocations": map[string]any{"data": allocs},
+			"user":        map[string]any{"attributes": map[string]any{"username": "alice"}},
+			"egg":         map[string]any{"attributes": map[string]any{"name": "Rust"}},
+			"node":        map[string]any{"attributes": map[string]any{"name": "fra-1"}},
+		},
+	}
+}
+
+func newPanel(t *testing.T, pages ...[]map[string]any) (*fakePanel, *Client) {
+	t.Helper()
+	panel := &fakePanel{t: t, pages: pages}
+	srv := httptest.NewServer(panel)
+	t.Cleanup(srv.Close)
+	return panel, NewClient(srv.URL+"/", testKey, srv.Client())
+}
+
+func TestServersPagination(t *testing.T) {
+	var pages [][]map[string]any
+	for p := 0; p < 3; p++ {
+		var page []map[string]any
+		for i := 0; i < 2; i++ {
+			n := p*2 + i
+			page = append(page, panelServer(fmt.Sprintf("uuid-%d", n), fmt.Sprintf("id%d", n), fmt.Sprintf("server %d", n), 27015+n, ""))
+		}
+		pages = append(pages, page)
+	}
+	panel, client := newPanel(t, pages...)
+
+	servers, err := client.Servers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := panel.requests.Load(); got != 3 {
+		t.Errorf("%d requests, want one per page", got)
+	}
+	if len(servers) != 6 {
+		t.Fatalf("got %d servers, want 6", len(servers))
+	}
+
+	srv := servers["uuid-5"]
+	want := Server{UUID: "uuid-5", Identifier: "id5", Name: "server 5", Owner: "alice", Egg: "Rust", Node: "fra-1"}
+	if srv.UUID != want.UUID || srv.Identifier != want.Identifier || srv.Name != want.Name ||
+		srv.Owner != want.Owner || srv.Egg != want.Egg || srv.Node != want.Node {
+		t.Errorf("server = %+v, want %+v", srv, want)
+	}
+	if len(srv.Ports) != 1 || srv.Ports[0].Port != 27020 {
+		t.Errorf("ports = %v, want [27020]", srv.Ports)
+	}
+}
+
+func TestServersAuth(t *testing.T) {
+	panel := &fakePanel{t: t, pages: [][]map[string]any{{}}}
+	srv := httptest.NewServer(panel)
+	defer srv.Close()
+
+	if _, err := NewClient(srv.URL, "wrong", srv.Client()).Servers(context.Background()); err == nil {
+		t.Error("wrong API key accepted")
+	}
+	if _, err := NewClient(srv.URL, testKey, srv.Client()).Servers(context.Background()); err != nil {
+		t.Errorf("Bearer API key rejected: %v", err)
+	}
+}
+
+// runEnricher starts e and waits for its first successful refresh.
+func runEnricher(t *testing.T, e *Enricher) chan struct{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	changed := make(chan struct{}, 1)
+	go e.Run(ctx, changed)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no refresh from enricher")
+	}
+	return changed
+}
+
+func TestEnrich(t *testing.T) {
+	_, client := newPanel(t, []map[string]any{
+		panelServer("uuid-1", "abcd1234", "Main", 27015, "", 27016, "query", 28016, "rcon"),
+	})
+	e := NewEnricher(client, time.Hour)
+	runEnricher(t, e)
+
+	servers := e.Enrich([]discovery.ServerMetadata{
+		{ServerID: "abcd1234", Ports: []discovery.PortRange{{Start: 27015, End: 27015, Role: discovery.RoleGame}}, Labels: map[string]string{"game": "rust"}},
+		{ServerID: "unknown", Ports: []discovery.PortRange{{Start: 30000, End: 30000}}},
+	})
+
+	srv := servers[0]
+	if srv.Labels[LabelName] != "Main" || srv.Labels[LabelOwner] != "alice" || srv.Labels[LabelEgg] != "Rust" ||
+		srv.Labels[LabelNode] != "fra-1" || srv.Labels["game"] != "rust" {
+		t.Errorf("labels = %v", srv.Labels)
+	}
+	want := []discovery.PortRange{
+		{Start: 27015, End: 27015, Role: discovery.RoleGame},
+		{Start: 27016, End: 27016, Role: discovery.RoleQuery},
+		{Start: 28016, End: 28016, Role: discovery.RoleRCON},
+	}
+	if len(srv.Ports) != len(want) {
+		t.Fatalf("ports = %v, want %v", srv.Ports, want)
+	}
+	for i := range want {
+		if srv.Ports[i] != want[i] {
+			t.Fatalf("ports = %v, want %v", srv.Ports, want)
+		}
+	}
+
+	if servers[1].Labels != nil || len(servers[1].Ports) != 1 {
+		t.Errorf("server not on the panel changed: %+v", servers[1])
+	}
+}
+
+func TestEnricherCachesForTTL(t *testing.T) {
+	panel, client := newPanel(t, []map[string]any{panelServer("uuid-1", "abcd1234", "Main", 27015, "")})
+	e := NewEnricher(client, time.Hour)
+	runEnricher(t, e)
+
+	for i := 0; i < 10; i++ {
+		e.Enrich([]discovery.ServerMetadata{{ServerID: "uuid-1"}})
+	}
+	if got := panel.requests.Load(); got != 1 {
+		t.Errorf("%d panel requests within the TTL, want 1", got)
+	}
+}
+
+func TestEnricherRefreshesAfterTTL(t *testing.T) {
+	panel, client := newPanel(t, []map[string]any{panelServer("uuid-1", "abcd1234", "Main", 27015, "")})
+	e := NewEnricher(client, 20*time.Millisecond)
+	changed := runEnricher(t, e)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no refresh after the TTL")
+	}
+	if got := panel.requests.Load(); got < 2 {
+		t.Errorf("%d panel requests, want a refresh", got)
+	}
+}
+
+func TestEnricherKeepsResultsOnPanelError(t *testing.T) {
+	panel, client := newPanel(t, []map[string]any{panelServer("uuid-1", "abcd1234", "Main", 27015, "")})
+	e := NewEnricher(client, time.Hour)
+	runEnricher(t, e)
+
+	panel.fail.Store(true)
+	if err := e.refresh(context.Background()); err == nil {
+		t.Fatal("refresh succeeded against a failing panel")
+	}
+
+	servers := e.Enrich([]discovery.ServerMetadata{{ServerID: "uuid-1"}})
+	if servers[0].Labels[LabelName] != "Main" {
+		t.Errorf("labels = %v, want the cached panel fields", servers[0].Labels)
+	}
+}
+
+func TestEnrichBeforeFirstRefresh(t *testing.T) {
+	panel, client := newPanel(t)
+	panel.fail.Store(true)
+	e := NewEnricher(client, time.Hour)
+
+	in := []discovery.ServerMetadata{{ServerID: "uuid-1", Ports: []discovery.PortRange{{Start: 27015, End: 27015}}}}
+	servers := e.Enrich(in)
+	if len(servers) != 1 || servers[0].Labels != nil {
+		t.Errorf("servers = %+v, want them unchanged", servers)
+	}
+	if got := panel.requests.Load(); got != 0 {
+		t.Errorf("Enrich queried the panel %d times", got)
+	}
+}
@@ -0,0 +1,147 @@
+package pterodactyl
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+const (
+	LabelName  = "panel_name"
+	LabelOwner = "panel_owner"
+	LabelEgg   = "panel_egg"
+	LabelNode  = "panel_node"
+)
+
+// MinCacheTTL is the shortest refresh interval accepted for the panel, which
+// is queried once per page of servers on every refresh.
+const MinCacheTTL = 10 * time.Second
+
+// Enricher matches discovered servers to panel servers by UUID or short
+// identifier, attaches the panel fields as labels and adds every allocation
+// of the server as a port. Run refreshes the panel servers every ttl in the
+// background, so Enrich never waits for the panel; when a refresh fails the
+// previous results are kept and it is retried after half the ttl.
+type Enricher struct {
+	client *Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	servers map[string]Server
+}
+
+func NewEnricher(client *Client, ttl time.Duration) *Enricher {
+	return &Enricher{
+		client:  client,
+		ttl:     ttl,
+		servers: make(map[string]Server),
+	}
+}
+
+// Run fetches the panel servers right away and then every ttl, signalling
+// changed after each successful refresh. It returns once ctx is cancelled.
+func (e *Enricher) Run(ctx context.Context, changed chan<- struct{}) {
+	for {
+		wait := e.ttl
+		if err := e.refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("Error fetching servers from panel", "error", err)
+			// Retry sooner than the ttl, but without hammering a failing
+			// panel.
+			wait = e.ttl / 2
+		} else {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (e *Enricher) Enrich(servers []discovery.ServerMetadata) []discovery.ServerMetadata {
+	e.mu.Lock()
+	panel := e.servers
+	e.mu.Unlock()
+
+	if len(panel) == 0 {
+		return servers
+	}
+
+	enriched := make([]discovery.ServerMetadata, 0, len(servers))
+	for _, srv := range servers {
+		ps, ok := panel[srv.ServerID]
+		if !ok {
+			enriched = append(enriched, srv)
+			continue
+		}
+
+		srvLabels := make(map[string]string, len(srv.Labels)+4)
+		maps.Copy(srvLabels, srv.Labels)
+		srvLabels[LabelName] = ps.Name
+		srvLabels[LabelOwner] = ps.Owner
+		srvLabels[LabelEgg] = ps.Egg
+		srvLabels[LabelNode] = ps.Node
+		srv.Labels = srvLabels
+
+		srv.Ports = addAllocations(srv.Ports, ps.Ports)
+		enriched = append(enriched, srv)
+	}
+	return enriched
+}
+
+// refresh replaces the cached panel servers, keyed by both UUID and
+// identifier.
+func (e *Enricher) refresh(ctx context.Context) error {
+	servers, err := e.client.Servers(ctx)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]Server, 2*len(servers))
+	for _, srv := range servers {
+		byID[srv.UUID] = srv
+		if srv.Identifier != "" {
+			byID[srv.Identifier] = srv
+		}
+	}
+
+	slog.Debug("Fetched servers from panel", "count", len(servers))
+	e.mu.Lock()
+	e.servers = byID
+	e.mu.Unlock()
+	return nil
+}
+
+// addAllocations appends the allocations not already covered by ports. The
+// allocation notes pick the role, e.g. "query"; anything else is a game port.
+func addAllocations(ports []discovery.PortRange, allocs []Allocation) []discovery.PortRange {
+	out := append([]discovery.PortRange(nil), ports...)
+	for _, a := range allocs {
+		if a.Port <= 0 || a.Port > 65535 || covered(out, a.Port) {
+			continue
+		}
+		out = append(out, discovery.PortRange{Start: a.Port, End: a.Port, Role: discovery.RoleFromName(a.Notes)})
+	}
+	return out
+}
+
+func covered(ports []discovery.PortRange, port int) bool {
+	for _, r := range ports {
+		if port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package static
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the layout of a static server file. JSON works as well, being a
+// subset of YAML.
+//
+//	servers:
+//	  - id: survival-1
+//	    ports: "game:25565,query:25566"
+//	    profile: minecraft
+//	    labels:
+//	      game: minecraft
+type File struct {
+	Servers []Server `yaml:"servers" json:"servers"`
+}
+
+type Server struct {
+	ID      string            `yaml:"id" json:"id"`
+	Ports   string            `yaml:"ports" json:"ports"`
+	Profile string            `yaml:"profile" json:"profile"`
+	Labels  map[string]string `yaml:"labels" json:"labels"`
+}
+
+// Watcher serves game servers listed in a file, for servers that are not
+// managed by a container runtime. The file is reloaded whenever it changes.
+type Watcher struct {
+	path string
+}
+
+func NewWatcher(path string) *Watcher {
+	return &Watcher{path: path}
+}
+
+func (w *Watcher) Name() string {
+	return "static"
+}
+
+// Run sends the servers in the file on updates, and again after every change
+// to it. A file that fails to load keeps the last good server set.
+func (w *Watcher) Run(ctx context.Context, updates chan<- []discovery.ServerMetadata) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("Failed to watch static server file", "path", w.path, "error", err)
+		return
+	}
+	defer fsw.Close()
+
+	// Watch the directory rather than the file so editors and config
+	// management that replace the file by renaming are picked up.
+	if err := fsw.Add(filepath.Dir(w.path)); err != nil {
+		slog.Error("Failed to watch static server file", "path", w.path, "error", err)
+		return
+	}
+
+	for {
+		servers, err := Load(w.path)
+		if err != nil {
+			slog.Error("Error loading static server file", "path", w.path, "error", err)
+		} else {
+			select {
+			case updates <- servers:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !w.waitForChange(ctx, fsw) {
+			return
+		}
+	}
+}
+
+// waitForChange blocks until the file was written, created or replaced. It
+// returns false once ctx is cancelled.
+func (w *Watcher) waitForChange(ctx context.Context, fsw *fsnotify.Watcher) bool {
+	name := filepath.Clean(w.path)
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return false
+			}
+			slog.Warn("Static server file watch error", "path", w.path, "error", err)
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return false
+			}
+			if filepath.Clean(ev.Name) == name && ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+				return true
+			}
+		}
+	}
+}
+
+// Load reads the server file at path.
+func Load(path string) ([]discovery.ServerMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	now := time.Now()
+	servers := make([]discovery.ServerMetadata, 0, len(f.Servers))
+	seen := make(map[string]bool, len(f.Servers))
+	for i, s := range f.Servers {
+		if s.ID == "" {
+			return nil, fmt.Errorf("server %d has no id", i)
+		}
+		if seen[s.ID] {
+			return nil, fmt.Errorf("server %s is listed twice", s.ID)
+		}
+		seen[s.ID] = true
+
+		ports, err := discovery.ParsePortSpec(s.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %w", s.ID, err)
+		}
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("server %s has no ports", s.ID)
+		}
+
+		var gamePort int
+		for _, r := range ports {
+			if r.Role == discovery.RoleGame {
+				gamePort = r.Start
+				break
+			}
+		}
+
+		servers = append(servers, discovery.ServerMetadata{
+			ServerID:    s.ID,
+			GamePort:    gamePort,
+			Ports:       ports,
+			Profile:     s.Profile,
+			Labels:      s.Labels,
+			LastUpdated: now,
+		})
+	}
+
+	return servers, nil
+}
@@ -0,0 +1,90 @@
+package static
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/rxtx-hosting/flowlens/pkg/discovery"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []discovery.ServerMetadata
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			file: `
+servers:
+  - id: survival-1
+    ports: "query:25566,game:25565"
+    profile: minecraft
+    labels:
+      game: minecraft
+  - id: cs-1
+    ports: "27015"
+`,
+			want: []discovery.ServerMetadata{
+				{
+					ServerID: "survival-1",
+					GamePort: 25565,
+					Ports:    []discovery.PortRange{{Start: 25566, End: 25566, Role: discovery.RoleQuery}, {Start: 25565, End: 25565, Role: discovery.RoleGame}},
+					Profile:  "minecraft",
+					Labels:   map[string]string{"game": "minecraft"},
+				},
+				{ServerID: "cs-1", GamePort: 27015, Ports: []discovery.PortRange{{Start: 27015, End: 27015, Role: discovery.RoleGame}}},
+			},
+		},
+		{
+			name: "json",
+			file: `{"servers": [{"id": "voice-1", "ports": "voice:9987"}]}`,
+			want: []discovery.ServerMetadata{
+				{ServerID: "voice-1", Ports: []discovery.PortRange{{Start: 9987, End: 9987, Role: discovery.RoleVoice}}},
+			},
+		},
+		{name: "no servers", file: "servers: []", want: []discovery.ServerMetadata{}},
+		{name: "missing id", file: `servers: [{ports: "27015"}]`, wantErr: true},
+		{name: "duplicate id", file: `servers: [{id: a, ports: "27015"}, {id: a, ports: "27016"}]`, wantErr: true},
+		{name: "no ports", file: `servers: [{id: a, ports: ""}]`, wantErr: true},
+		{name: "bad ports", file: `servers: [{id: a, ports: "web:80"}]`, wantErr: true},
+		{name: "invalid yaml", file: "servers: [", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "servers.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Load() = %+v, want %+v", got, tt.want)
+			}
+			for i, srv := range got {
+				want := tt.want[i]
+				if srv.ServerID != want.ServerID || srv.GamePort != want.GamePort || srv.Profile != want.Profile ||
+					!slices.Equal(srv.Ports, want.Ports) || !maps.Equal(srv.Labels, want.Labels) {
+					t.Errorf("server %d = %+v, want %+v", i, srv, want)
+				}
+				if srv.LastUpdated.IsZero() {
+					t.Errorf("server %d has no LastUpdated", i)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file loaded")
+	}
+}